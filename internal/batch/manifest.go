@@ -0,0 +1,212 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package batch
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Entry is a dataset listed in a manifest
+type Entry struct {
+	// Images is a directory of images
+	Images string `yaml:"images"`
+
+	// Output is the directory where to save the results
+	Output string `yaml:"output"`
+
+	// Node is the name of a node, "auto" or a @tag group
+	Node string `yaml:"node"`
+
+	// Options are task options, as they would be passed on the
+	// command line (e.g. "--dsm --orthophoto-resolution 2"),
+	// see SplitOptions
+	Options string `yaml:"options"`
+}
+
+// Load reads a manifest in CSV or YAML format, depending on the file
+// extension. Relative paths are resolved from the manifest directory.
+func Load(manifestPath string) ([]Entry, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(manifestPath)) {
+	case ".csv":
+		entries, err = ParseCSV(f)
+	case ".yml", ".yaml":
+		var data []byte
+		if data, err = ioutil.ReadAll(f); err == nil {
+			entries, err = ParseYAML(data)
+		}
+	default:
+		return nil, errors.New("Unknown manifest format " + manifestPath + " (use a .csv, .yml or .yaml file)")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(manifestPath)
+	for i, e := range entries {
+		if e.Images != "" && !filepath.IsAbs(e.Images) {
+			entries[i].Images = filepath.Join(dir, e.Images)
+		}
+		if e.Output != "" && !filepath.IsAbs(e.Output) {
+			entries[i].Output = filepath.Join(dir, e.Output)
+		}
+	}
+
+	return entries, nil
+}
+
+// ParseCSV parses a CSV manifest. The first row must be a header
+// with an "images" column and optionally "output", "node" and "options".
+func ParseCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("Empty manifest")
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "images", "output", "node", "options":
+			columns[name] = i
+		default:
+			return nil, errors.New("Unknown manifest column: " + name)
+		}
+	}
+	if _, ok := columns["images"]; !ok {
+		return nil, errors.New("The manifest must have an images column")
+	}
+
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	entries := []Entry{}
+	for _, row := range rows[1:] {
+		entries = append(entries, Entry{
+			Images:  field(row, "images"),
+			Output:  field(row, "output"),
+			Node:    field(row, "node"),
+			Options: field(row, "options"),
+		})
+	}
+
+	return entries, validate(entries)
+}
+
+// ParseYAML parses a YAML manifest, which is a list of entries
+func ParseYAML(data []byte) ([]Entry, error) {
+	entries := []Entry{}
+	if err := yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, validate(entries)
+}
+
+func validate(entries []Entry) error {
+	if len(entries) == 0 {
+		return errors.New("The manifest does not list any datasets")
+	}
+	for i, e := range entries {
+		if e.Images == "" {
+			return errors.New("Dataset #" + strconv.Itoa(i+1) + " does not have images")
+		}
+		if _, err := SplitOptions(e.Options); err != nil {
+			return errors.New("Dataset #" + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// SplitOptions splits options into arguments like a shell would:
+// arguments are separated by spaces, unless they are quoted
+// (e.g. --name "my project") or escaped with a backslash
+func SplitOptions(options string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range options {
+		switch {
+		case escaped:
+			// Inside double quotes, backslashes only escape " and \
+			if quote == '"' && r != '"' && r != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, errors.New("Unterminated quote or escape in options: " + options)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,121 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package batch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	entries, err := ParseCSV(strings.NewReader(`images,node,options
+# comment
+site1,auto,--dsm --orthophoto-resolution 2
+site2, @fast ,
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Expected 2 entries")
+	}
+	if entries[0].Images != "site1" || entries[0].Node != "auto" || entries[0].Options != "--dsm --orthophoto-resolution 2" || entries[0].Output != "" {
+		t.Error("Unexpected first entry")
+	}
+	if entries[1].Images != "site2" || entries[1].Node != "@fast" {
+		t.Error("Unexpected second entry")
+	}
+
+	if _, err := ParseCSV(strings.NewReader("node\nauto\n")); err == nil {
+		t.Error("Expected error without images column")
+	}
+	if _, err := ParseCSV(strings.NewReader("images,foo\na,b\n")); err == nil {
+		t.Error("Expected error for unknown column")
+	}
+	if _, err := ParseCSV(strings.NewReader("images,node\n,auto\n")); err == nil {
+		t.Error("Expected error for missing images")
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	entries, err := ParseYAML([]byte(`
+- images: site1
+  output: out/site1
+  options: --fast-orthophoto
+- images: site2
+  node: default
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Output != "out/site1" || entries[0].Options != "--fast-orthophoto" || entries[1].Node != "default" {
+		t.Error("Unexpected entries")
+	}
+
+	if _, err := ParseYAML([]byte("- images: a\n  nodes: x\n")); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+func TestSplitOptions(t *testing.T) {
+	cases := map[string][]string{
+		"":                                     {},
+		"  --dsm   --orthophoto-resolution 2 ": {"--dsm", "--orthophoto-resolution", "2"},
+		`--name "my project" --dsm`:            {"--name", "my project", "--dsm"},
+		`--name 'it''s' --tag=a\ b`:            {"--name", "its", "--tag=a b"},
+		`--name "say \"hi\" \n" ""`:            {"--name", `say "hi" \n`, ""},
+	}
+	for options, expected := range cases {
+		args, err := SplitOptions(options)
+		if err != nil {
+			t.Error(options, err)
+			continue
+		}
+		if strings.Join(args, "|") != strings.Join(expected, "|") || len(args) != len(expected) {
+			t.Errorf("%s: expected %q, got %q", options, expected, args)
+		}
+	}
+
+	if _, err := SplitOptions(`--name "my project`); err == nil {
+		t.Error("Expected error for unterminated quote")
+	}
+	if _, err := ParseCSV(strings.NewReader("images,options\nsite1,--name 'a\n")); err == nil {
+		t.Error("Expected error for invalid options")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "odm")
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "flights.csv")
+	ioutil.WriteFile(manifest, []byte("images,output\nsite1,/abs/out\n"), 0644)
+
+	entries, err := Load(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Images != filepath.Join(dir, "site1") || entries[0].Output != "/abs/out" {
+		t.Error("Relative paths should be resolved from the manifest directory")
+	}
+
+	if _, err := Load(filepath.Join(dir, "flights.txt")); err == nil {
+		t.Error("Expected error for missing manifest")
+	}
+}
@@ -42,6 +42,19 @@ var argsCmd = &cobra.Command{
 			logger.Error(err)
 		}
 
+		if logger.JSON() {
+			for _, option := range options {
+				logger.Event("option", "", logger.Fields{
+					"name":    option.Name,
+					"type":    option.Type,
+					"default": option.Value,
+					"domain":  option.Domain,
+					"help":    option.Help,
+				})
+			}
+			return
+		}
+
 		logger.Info("Args:")
 		logger.Info("")
 
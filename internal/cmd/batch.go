@@ -0,0 +1,286 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/batch"
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/fs"
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+
+	"github.com/spf13/cobra"
+)
+
+var concurrency int
+
+var batchCmd = &cobra.Command{
+	Use:   "batch <manifest>",
+	Short: "Process the datasets listed in a CSV or YAML manifest",
+	Long: `Process the datasets listed in a CSV or YAML manifest.
+
+Each dataset has a directory of images and optionally an output directory
+(defaults to a subdirectory of --output named after the images directory),
+a node (defaults to --node; "auto" and @tag groups are supported) and task
+options written as on the command line. For example:
+
+  images,node,options
+  flights/site1,auto,--dsm
+  flights/site2,@fast,--fast-orthophoto
+
+or:
+
+  - images: flights/site1
+    node: auto
+    options: --dsm
+  - images: flights/site2
+    output: results/site2
+
+Up to --concurrency datasets are processed at the same time. A failed
+dataset doesn't stop the others; a summary is printed at the end.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		entries, err := batch.Load(args[0])
+		if err != nil {
+			logger.Error(err)
+		}
+
+		if force && !cmd.Flags().Changed("output-mode") {
+			outputMode = outputModeOverwrite
+		}
+
+		datasets, err := batchDatasets(entries)
+		if err != nil {
+			logger.Error(err)
+		}
+
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		// Progress bars of different datasets would overlap
+		logger.DisableProgress()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		logger.Info("Processing " + strconv.Itoa(len(datasets)) + " datasets, " + strconv.Itoa(concurrency) + " at a time")
+
+		results := runBatch(ctx, user, openJournal(), datasets)
+
+		logger.Info("")
+		logger.Info(batchSummary(datasets, results))
+		printMetricsSummary()
+
+		for _, r := range results {
+			if r.Status != journal.StatusCompleted {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// batchDatasets converts manifest entries to datasets
+func batchDatasets(entries []batch.Entry) ([]dataset, error) {
+	datasets := []dataset{}
+	outputs := map[string]string{}
+
+	for _, e := range entries {
+		d := dataset{
+			Name:       e.Images,
+			Input:      absPath(e.Images),
+			Node:       e.Node,
+			Output:     e.Output,
+			OutputMode: outputMode,
+			OnComplete: onComplete,
+			OnFailure:  onFailure,
+		}
+		if d.Node == "" {
+			d.Node = nodeName
+		}
+		if d.Output == "" {
+			d.Output = filepath.Join(outputPath, filepath.Base(e.Images))
+		}
+
+		absOutput, err := filepath.Abs(d.Output)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := outputs[absOutput]; ok {
+			return nil, errors.New(other + " and " + e.Images + " have the same output directory " + d.Output)
+		}
+		outputs[absOutput] = e.Images
+
+		if fs.IsDirectory(e.Images) {
+			d.Files, _ = parseArgs([]string{e.Images})
+			d.Files = filterImagesAndText(d.Files)
+		}
+		if d.Options, err = batch.SplitOptions(e.Options); err != nil {
+			return nil, err
+		}
+
+		datasets = append(datasets, d)
+	}
+
+	return datasets, nil
+}
+
+// runBatch processes datasets with up to concurrency workers and
+// returns the final journal entry of each dataset
+func runBatch(ctx context.Context, user config.Configuration, j *journal.Journal, datasets []dataset) []journal.Entry {
+	results := make([]journal.Entry, len(datasets))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runBatchDataset(ctx, user, j, datasets[i])
+			}
+		}()
+	}
+
+	for i := range datasets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func runBatchDataset(ctx context.Context, user config.Configuration, j *journal.Journal, d dataset) journal.Entry {
+	name := d.Name
+	log := logger.With(logger.Fields{"dataset": name})
+
+	if ctx.Err() != nil {
+		return journal.Entry{Input: d.Input, Output: d.Output, Status: journal.StatusCanceled}
+	}
+	if !fs.IsDirectory(d.Input) {
+		err := d.Input + " is not a directory"
+		log.Warn(err)
+		return journal.Entry{Input: d.Input, Output: d.Output, Status: journal.StatusFailed, Error: err}
+	}
+	if len(d.Files) == 0 {
+		err := "No images found in " + d.Input
+		log.Warn(err)
+		return journal.Entry{Input: d.Input, Output: d.Output, Status: journal.StatusFailed, Error: err}
+	}
+
+	lastStatus := ""
+	entry, err := processDataset(ctx, user, j, d, odm.RunSettings{
+		ParallelConnections: uploadConnections,
+		AutoConnections:     autoConnections,
+		MaxUploadRetries:    maxUploadRetries,
+		Webhook:             webhook,
+
+		// Only warnings and errors, the task output is saved to task_output.log
+		Logger: log.WithLevel(logger.WarnLevel),
+	}, func(e journal.Entry) {
+		if e.Status == lastStatus || e.Status == journal.StatusPending || e.Done() {
+			return
+		}
+		lastStatus = e.Status
+
+		message := name + ": " + e.Status
+		if e.Node != "" {
+			message += " (" + e.Node + ")"
+		}
+		log.Event("dataset_status", message, logger.Fields{"status": e.Status, "node": e.Node, "uuid": e.UUID})
+	})
+
+	if err != nil {
+		log.Warn(err)
+	} else {
+		logger.Info(name + ": completed, results saved in " + entry.Output)
+	}
+	log.Event("dataset_result", "", logger.Fields{
+		"status": entry.Status,
+		"node":   entry.Node,
+		"uuid":   entry.UUID,
+		"output": entry.Output,
+		"error":  entry.Error,
+	})
+
+	return entry
+}
+
+// batchSummary returns a table with the results of a batch
+func batchSummary(datasets []dataset, results []journal.Entry) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATASET\tSTATUS\tNODE\tUUID\tTIME\tOUTPUT")
+
+	completed := 0
+	for i, r := range results {
+		if r.Status == journal.StatusCompleted {
+			completed++
+		}
+
+		duration := "-"
+		if !r.Started.IsZero() {
+			duration = r.Updated.Sub(r.Started).Round(time.Second).String()
+		}
+
+		output := r.Output
+		if r.Error != "" {
+			output = r.Error
+		}
+
+		fmt.Fprintln(w, datasets[i].Name+"\t"+r.Status+"\t"+dash(r.Node)+"\t"+dash(r.UUID)+"\t"+duration+"\t"+output)
+	}
+	w.Flush()
+
+	return buf.String() + "\n" + strconv.Itoa(completed) + "/" + strconv.Itoa(len(results)) + " datasets completed"
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	batchCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 2, "number of datasets to process at the same time")
+	batchCmd.Flags().StringVarP(&outputPath, "output", "o", "./output", "directory where to store the results of datasets without an output directory")
+	batchCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "processing node to use for datasets without a node (\"auto\" picks the least busy node, \"@tag\" the least busy node with a tag)")
+	batchCmd.Flags().BoolVarP(&force, "force", "f", false, "replace the contents of output directories that already exist (same as --output-mode overwrite)")
+	batchCmd.Flags().StringVar(&outputMode, "output-mode", outputModeNew, "how to handle existing output directories: new, overwrite, clean or timestamped (see odm --help)")
+	batchCmd.Flags().StringVarP(&parallelConnections, "parallel-connections", "p", "5", "parallel upload connections per dataset. Set to 1 to disable parallel uploads, or to \"auto\" to adjust them to the measured throughput")
+	batchCmd.Flags().IntVarP(&maxUploadRetries, "max-upload-retries", "m", 10, "max retries before giving up on a file upload when using parallel upload connections")
+	addHookFlags(batchCmd)
+
+	rootCmd.AddCommand(batchCmd)
+}
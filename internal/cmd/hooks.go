@@ -0,0 +1,75 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var webhook string
+var onComplete string
+var onFailure string
+
+// addHookFlags adds the flags to notify other programs about tasks
+func addHookFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.StringVar(&webhook, "webhook", "", "URL that the node calls (POST) when a task finishes")
+	flags.StringVar(&onComplete, "on-complete", "", "shell command to run after the results of a task are downloaded")
+	flags.StringVar(&onFailure, "on-failure", "", "shell command to run when a task fails or is canceled")
+}
+
+// runHook runs a shell command with the details of a task in the
+// ODM_UUID, ODM_OUTPUT, ODM_STATUS, ODM_NODE and ODM_ERROR environment
+// variables. Its output is logged and failures are only reported as
+// warnings, since the task itself has already finished.
+func runHook(name string, command string, entry journal.Entry, log *logger.Logger) {
+	if command == "" {
+		return
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"ODM_UUID="+entry.UUID,
+		"ODM_OUTPUT="+entry.Output,
+		"ODM_STATUS="+entry.Status,
+		"ODM_NODE="+entry.Node,
+		"ODM_ERROR="+entry.Error,
+	)
+
+	log.Debug("Running " + name + " hook: " + command)
+	output, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			log.Info(line)
+		}
+	}
+	if err != nil {
+		log.Warn("The " + name + " hook failed: " + err.Error())
+	}
+}
@@ -16,6 +16,8 @@
 package cmd
 
 import (
+	"strings"
+
 	"github.com/OpenDroneMap/CloudODM/internal/config"
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
 	"github.com/spf13/cobra"
@@ -23,6 +25,7 @@ import (
 
 var username string
 var password string
+var credentialStore string
 
 var loginCmd = &cobra.Command{
 	Use:   "login [--node default]",
@@ -30,6 +33,13 @@ var loginCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		user := config.Initialize()
 
+		if credentialStore != "" {
+			if err := user.SetCredentialStore(credentialStore); err != nil {
+				logger.Error(err)
+			}
+		}
+		user.MigrateTokens()
+
 		if user.CheckLogin(nodeName, username, password) != nil {
 			logger.Info("Logged in")
 		}
@@ -40,6 +50,7 @@ func init() {
 	loginCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "Processing node to use")
 	loginCmd.Flags().StringVar(&username, "username", "", "Username")
 	loginCmd.Flags().StringVar(&password, "password", "", "Password")
+	loginCmd.Flags().StringVar(&credentialStore, "credential-store", "", "where to store tokens: "+strings.Join(config.CredentialStores, ", ")+" (tokens are migrated to it; the encrypted store reads its passphrase from "+config.PassphraseEnv+")")
 
 	rootCmd.AddCommand(loginCmd)
 }
@@ -33,7 +33,9 @@ var logoutCmd = &cobra.Command{
 		}
 
 		node.Token = ""
-		user.UpdateNode(nodeName, *node)
+		if err := user.UpdateNode(nodeName, *node); err != nil {
+			logger.Error(err)
+		}
 
 		logger.Info("Logged out")
 	},
@@ -0,0 +1,75 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/metrics"
+)
+
+var metricsAddr string
+
+// startMetricsServer exposes the metrics at /metrics on addr
+func startMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	go http.Serve(listener, mux)
+
+	logger.Debug("Serving metrics on http://" + listener.Addr().String() + "/metrics")
+	return nil
+}
+
+// printMetricsSummary prints the metrics collected during the
+// run, unless they are exposed with --metrics-addr
+func printMetricsSummary() {
+	if metricsAddr != "" {
+		return
+	}
+
+	values := metrics.Default.Snapshot()
+	if logger.JSON() {
+		fields := logger.Fields{}
+		for k, v := range values {
+			fields[k] = v
+		}
+		logger.Event("metrics", "", logger.Fields{"metrics": fields})
+		return
+	}
+
+	names := []string{}
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("Metrics:")
+	for _, name := range names {
+		sb.WriteString("\n  " + name + " " + strconv.FormatFloat(values[name], 'f', -1, 64))
+	}
+	logger.Info(sb.String())
+}
@@ -16,9 +16,14 @@
 package cmd
 
 import (
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
 
 	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
 	"github.com/spf13/cobra"
 )
 
@@ -30,8 +35,18 @@ var nodeCmd = &cobra.Command{
 		user := config.Initialize()
 
 		for k, n := range user.Nodes {
-			if logger.VerboseFlag {
-				logger.Info(k + " - " + n.String())
+			if logger.JSON() {
+				logger.Event("node", "", logger.Fields{
+					"name": k,
+					"url":  n.URL,
+					"tags": n.Tags,
+				})
+			} else if logger.Enabled(logger.DebugLevel) {
+				tags := ""
+				if len(n.Tags) > 0 {
+					tags = " [" + strings.Join(n.Tags, ", ") + "]"
+				}
+				logger.Info(k + " - " + n.String() + tags)
 			} else {
 				logger.Info(k)
 			}
@@ -66,8 +81,155 @@ var removeCmd = &cobra.Command{
 	},
 }
 
+var transportSettings odm.Transport
+var connectTimeout time.Duration
+var readTimeout time.Duration
+var authHeader bool
+
+var setCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Change how to connect to a processing node (proxy, certificates, timeouts, authentication)",
+	Long:  "Change how to connect to a processing node (proxy, certificates, timeouts, authentication). Only the flags that are passed are changed, pass an empty value to reset a setting.",
+	Args:  cobra.ExactValidArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		node, err := user.GetNode(args[0])
+		if err != nil {
+			logger.Error(err)
+		}
+
+		transport := odm.Transport{}
+		if node.Transport != nil {
+			transport = *node.Transport
+		}
+
+		flags := cmd.Flags()
+		if flags.Changed("proxy") {
+			transport.Proxy = transportSettings.Proxy
+		}
+		if flags.Changed("ca-cert") {
+			transport.CACert = transportSettings.CACert
+		}
+		if flags.Changed("client-cert") {
+			transport.ClientCert = transportSettings.ClientCert
+		}
+		if flags.Changed("client-key") {
+			transport.ClientKey = transportSettings.ClientKey
+		}
+		if flags.Changed("insecure") {
+			transport.InsecureSkipVerify = transportSettings.InsecureSkipVerify
+		}
+		if flags.Changed("connect-timeout") {
+			transport.ConnectTimeout = timeoutSeconds("connect-timeout", connectTimeout)
+		}
+		if flags.Changed("read-timeout") {
+			transport.ReadTimeout = timeoutSeconds("read-timeout", readTimeout)
+		}
+
+		if err := user.SetNodeTransport(args[0], transport); err != nil {
+			logger.Error(err)
+		}
+
+		if flags.Changed("auth-header") {
+			if err := user.SetNodeAuthHeader(args[0], authHeader); err != nil {
+				logger.Error(err)
+			}
+		}
+	},
+}
+
+var resetDefaultCmd = &cobra.Command{
+	Use:   "reset-default",
+	Short: "Set the default node to the most available public node",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if err := user.ResetDefaultNode(); err != nil {
+			logger.Error(err)
+		}
+	},
+}
+
+// timeoutSeconds converts the value of a timeout flag to the
+// whole seconds stored in the configuration
+func timeoutSeconds(flag string, timeout time.Duration) int {
+	if timeout < 0 || timeout%time.Second != 0 {
+		logger.Error("--" + flag + " must be a whole number of seconds (for example 10s or 2m), got " + timeout.String())
+	}
+	return int(timeout / time.Second)
+}
+
+var tagCmd = &cobra.Command{
+	Use:   "tag",
+	Short: "Manage node tags (use -n @tag to process with a group of nodes)",
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		tags := map[string][]string{}
+		for _, name := range user.NodeNames() {
+			for _, tag := range user.Nodes[name].Tags {
+				tags[tag] = append(tags[tag], name)
+			}
+		}
+
+		names := []string{}
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+
+		for _, tag := range names {
+			logger.Info(config.GroupPrefix + tag + " - " + strings.Join(tags[tag], ", "))
+		}
+	},
+}
+
+var tagAddCmd = &cobra.Command{
+	Use:   "add <name> <tag>...",
+	Short: "Add tags to a processing node",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if err := user.TagNode(args[0], args[1:]...); err != nil {
+			logger.Error(err)
+		}
+	},
+}
+
+var tagRemoveCmd = &cobra.Command{
+	Use:     "remove <name> <tag>...",
+	Short:   "Remove tags from a processing node",
+	Aliases: []string{"delete", "rm", "del"},
+	Args:    cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if err := user.UntagNode(args[0], args[1:]...); err != nil {
+			logger.Error(err)
+		}
+	},
+}
+
 func init() {
+	setCmd.Flags().StringVar(&transportSettings.Proxy, "proxy", "", "proxy URL (e.g. http://proxy:3128), defaults to the HTTP_PROXY/HTTPS_PROXY environment variables")
+	setCmd.Flags().StringVar(&transportSettings.CACert, "ca-cert", "", "PEM file with additional certificate authorities to trust")
+	setCmd.Flags().StringVar(&transportSettings.ClientCert, "client-cert", "", "PEM file with a client certificate (mutual TLS)")
+	setCmd.Flags().StringVar(&transportSettings.ClientKey, "client-key", "", "PEM file with the key of the client certificate")
+	setCmd.Flags().BoolVar(&transportSettings.InsecureSkipVerify, "insecure", false, "do not verify the node certificate (unsafe)")
+	setCmd.Flags().DurationVar(&connectTimeout, "connect-timeout", 0, "maximum time to establish a connection, in whole seconds (0 to disable)")
+	setCmd.Flags().DurationVar(&readTimeout, "read-timeout", 0, "maximum time to wait for data from the node, in whole seconds (0 to disable)")
+	setCmd.Flags().BoolVar(&authHeader, "auth-header", false, "send the token in an Authorization header instead of the URL (detected automatically on login)")
+
+	tagCmd.AddCommand(tagAddCmd)
+	tagCmd.AddCommand(tagRemoveCmd)
+
 	nodeCmd.AddCommand(addCmd)
 	nodeCmd.AddCommand(removeCmd)
+	nodeCmd.AddCommand(setCmd)
+	nodeCmd.AddCommand(tagCmd)
+	nodeCmd.AddCommand(resetDefaultCmd)
 	rootCmd.AddCommand(nodeCmd)
 }
@@ -0,0 +1,165 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/io"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/notify"
+	"github.com/spf13/cobra"
+)
+
+var notifyEvents []string
+var notifyTo []string
+var notifyFrom string
+var smtpHost string
+var smtpPort int
+var smtpUsername string
+var smtpPassword string
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manage notifications sent when tasks finish",
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		for _, name := range user.NotifierNames() {
+			n := user.Notifiers[name]
+			events := n.Events
+			if len(events) == 0 {
+				events = notify.Events
+			}
+
+			if logger.JSON() {
+				logger.Event("notifier", "", logger.Fields{
+					"name":   name,
+					"type":   n.Type,
+					"events": events,
+				})
+				continue
+			}
+
+			logger.Info(name + " - " + n.String() + " (" + strings.Join(events, ", ") + ")")
+		}
+	},
+}
+
+var notifyAddCmd = &cobra.Command{
+	Use:   "add <name> email|webhook|desktop [<url>]",
+	Short: "Add a notifier (replacing any notifier with the same name)",
+	Example: `  odm notify add team webhook https://hooks.slack.com/services/...
+  odm notify add me email --to me@example.com --smtp-host smtp.example.com --smtp-user me@example.com
+  odm notify add desktop desktop --events failed,canceled`,
+	Args: cobra.RangeArgs(2, 3),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		n := notify.Config{Type: args[1], Events: notifyEvents}
+		switch n.Type {
+		case notify.TypeWebhook:
+			if len(args) < 3 {
+				logger.Error("Webhook notifiers require a URL")
+			}
+			n.URL = args[2]
+		case notify.TypeEmail:
+			n.To = notifyTo
+			n.From = notifyFrom
+			n.Host = smtpHost
+			n.Port = smtpPort
+			n.Username = smtpUsername
+			n.Password = smtpPassword
+			if n.Username != "" && n.Password == "" && io.IsInteractive() {
+				n.Password = io.PromptPassword("Enter SMTP password")
+			}
+		}
+
+		if err := user.AddNotifier(args[0], n); err != nil {
+			logger.Error(err)
+		}
+	},
+}
+
+var notifyRemoveCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Short:   "Remove a notifier",
+	Aliases: []string{"delete", "rm", "del"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if !user.RemoveNotifier(args[0]) {
+			logger.Error("Notifier " + args[0] + " does not exist")
+		}
+	},
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test [<name>]",
+	Short: "Send a test notification",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		names := user.NotifierNames()
+		if len(args) > 0 {
+			if _, err := user.GetNotifier(args[0]); err != nil {
+				logger.Error(err)
+			}
+			names = args
+		}
+
+		failed := false
+		for _, name := range names {
+			n, err := user.GetNotifier(name)
+			if err != nil {
+				logger.Error(err)
+			}
+			err = n.Send(notify.Notification{
+				Status: "completed",
+				UUID:   "test",
+				Node:   "odm notify test",
+			})
+			if err != nil {
+				logger.Warn(name + ": " + err.Error())
+				failed = true
+			} else {
+				logger.Info(name + ": sent")
+			}
+		}
+		if failed {
+			logger.Error("Some notifications could not be sent")
+		}
+	},
+}
+
+func init() {
+	notifyAddCmd.Flags().StringSliceVar(&notifyEvents, "events", nil, "events to notify: "+strings.Join(notify.Events, ", ")+" (default all)")
+	notifyAddCmd.Flags().StringSliceVar(&notifyTo, "to", nil, "email recipients")
+	notifyAddCmd.Flags().StringVar(&notifyFrom, "from", "", "email sender (defaults to the SMTP username)")
+	notifyAddCmd.Flags().StringVar(&smtpHost, "smtp-host", "", "SMTP server")
+	notifyAddCmd.Flags().IntVar(&smtpPort, "smtp-port", 587, "SMTP port (465 for implicit TLS)")
+	notifyAddCmd.Flags().StringVar(&smtpUsername, "smtp-user", "", "SMTP username")
+	notifyAddCmd.Flags().StringVar(&smtpPassword, "smtp-password", "", "SMTP password (prompted if a username is set)")
+
+	notifyCmd.AddCommand(notifyAddCmd)
+	notifyCmd.AddCommand(notifyRemoveCmd)
+	notifyCmd.AddCommand(notifyTestCmd)
+
+	rootCmd.AddCommand(notifyCmd)
+}
@@ -0,0 +1,110 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/fs"
+)
+
+// Output modes for --output-mode
+const (
+	outputModeNew         = "new"
+	outputModeOverwrite   = "overwrite"
+	outputModeClean       = "clean"
+	outputModeTimestamped = "timestamped"
+)
+
+var outputModes = []string{outputModeNew, outputModeOverwrite, outputModeClean, outputModeTimestamped}
+
+// latestLinkName is the symbolic link pointing to the most recent
+// timestamped output
+const latestLinkName = "latest"
+
+// checkOutputDirectory validates the output mode and makes sure the output
+// directory can be used with it
+func checkOutputDirectory(outputPath string, mode string) error {
+	filesCount, err := fs.DirectoryFilesCount(outputPath)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case outputModeNew:
+		if filesCount > 0 {
+			return errors.New(outputPath + " already exists (pass --output-mode overwrite|clean|timestamped to reuse it)")
+		}
+	case outputModeClean:
+		// The directory is cleaned once the results are downloaded,
+		// make sure that will be possible before processing
+		if filesCount > 0 {
+			return fs.CanCleanDirectory(outputPath)
+		}
+	case outputModeOverwrite, outputModeTimestamped:
+	default:
+		return errors.New("Invalid output mode " + mode + " (valid modes are: " + strings.Join(outputModes, ", ") + ")")
+	}
+
+	return nil
+}
+
+// prepareOutputDirectory creates the output directory according to the
+// output mode and returns the directory where results should be stored.
+// In clean mode the directory is emptied later by odm.Run, once the new
+// results have been downloaded (see odm.RunSettings.CleanOutput).
+func prepareOutputDirectory(outputPath string, mode string) (string, error) {
+	if mode == outputModeTimestamped {
+		id, err := newUUID()
+		if err != nil {
+			return "", err
+		}
+		outputPath = filepath.Join(outputPath, time.Now().Format("2006-01-02T15-04-05")+"_"+id)
+	}
+
+	if !fs.IsDirectory(outputPath) {
+		if err := os.MkdirAll(outputPath, 0755); err != nil {
+			return "", err
+		}
+	}
+
+	return outputPath, nil
+}
+
+// updateLatestLink points the "latest" symbolic link in the parent of a
+// timestamped output directory to it
+func updateLatestLink(outputPath string) error {
+	parent := filepath.Dir(outputPath)
+	return fs.UpdateSymlink(filepath.Base(outputPath), filepath.Join(parent, latestLinkName))
+}
+
+// newUUID generates a random (version 4) UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var presetNode string
+
+var presetCmd = &cobra.Command{
+	Use:   "preset",
+	Short: "Manage presets of task options",
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		for _, name := range user.PresetNames() {
+			preset := user.Presets[name]
+			if logger.JSON() {
+				logger.Event("preset", "", logger.Fields{
+					"name":    name,
+					"node":    preset.Node,
+					"options": preset.Options,
+				})
+				continue
+			}
+
+			line := name + " - " + strings.Join(preset.Options, " ")
+			if preset.Node != "" {
+				line += " (node: " + preset.Node + ")"
+			}
+			logger.Info(line)
+		}
+	},
+}
+
+var presetAddCmd = &cobra.Command{
+	Use:     "add [--node <node>] <name> [args]",
+	Short:   "Add a preset (replacing any preset with the same name)",
+	Example: "  odm preset add --node auto fast --fast-orthophoto --orthophoto-resolution 5",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if presetNode != "" && !config.IsNodeGroup(presetNode) {
+			if _, err := user.GetNode(presetNode); err != nil {
+				logger.Error(err)
+			}
+		}
+
+		err := user.AddPreset(args[0], config.Preset{Node: presetNode, Options: args[1:]})
+		if err != nil {
+			logger.Error(err)
+		}
+	},
+}
+
+var presetRemoveCmd = &cobra.Command{
+	Use:     "remove <name>",
+	Short:   "Remove a preset",
+	Aliases: []string{"delete", "rm", "del"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if !user.RemovePreset(args[0]) {
+			logger.Error("Preset " + args[0] + " does not exist")
+		}
+	},
+}
+
+func init() {
+	presetAddCmd.Flags().StringVarP(&presetNode, "node", "n", "", "node to process the datasets with (\"auto\" and \"@tag\" are supported)")
+	presetAddCmd.Flags().SetInterspersed(false)
+
+	presetCmd.AddCommand(presetAddCmd)
+	presetCmd.AddCommand(presetRemoveCmd)
+
+	rootCmd.AddCommand(presetCmd)
+}
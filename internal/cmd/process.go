@@ -0,0 +1,253 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+)
+
+// dataset is a set of files to process with the same settings
+type dataset struct {
+	// ID of the journal entry (generated if empty)
+	ID string
+
+	// Name identifies the dataset in messages
+	Name string
+
+	// TaskName is the name of the task on the node
+	// (defaults to the name of the input directory)
+	TaskName    string
+	DateCreated time.Time
+
+	// Input describes where the files come from (e.g. a directory)
+	Input      string
+	Files      []string
+	Options    []string
+	Node       string
+	Output     string
+	OutputMode string
+
+	// Split is the approximate number of images per submodel
+	// (0 disables split-merge), see addSplitOptions
+	Split        int
+	SplitOverlap int
+
+	// OnComplete and OnFailure are shell commands to run when
+	// the task finishes (see runHook)
+	OnComplete string
+	OnFailure  string
+}
+
+// authMutex prevents concurrent login prompts
+var authMutex sync.Mutex
+
+// openJournal opens the task journal, logging a warning if it cannot be used
+func openJournal() *journal.Journal {
+	j, err := journal.Default()
+	if err != nil {
+		logger.Warn("Cannot open task journal: " + err.Error())
+		return nil
+	}
+	return j
+}
+
+// processDataset processes a dataset on the first of its candidate nodes
+// that accepts the task, recording its progress in the journal (if not nil)
+// and calling onUpdate (if not nil) every time it changes. The final journal
+// entry is returned.
+func processDataset(ctx context.Context, user config.Configuration, j *journal.Journal, d dataset, settings odm.RunSettings, onUpdate func(journal.Entry)) (journal.Entry, error) {
+	log := settings.Logger
+	if log == nil {
+		log = logger.With(nil)
+	}
+
+	id := d.ID
+	if id == "" {
+		var err error
+		if id, err = newUUID(); err != nil {
+			return journal.Entry{}, err
+		}
+	}
+
+	taskName := d.TaskName
+	if taskName == "" {
+		taskName = filepath.Base(d.Input)
+	}
+
+	entry := journal.Entry{
+		ID:      id,
+		Name:    taskName,
+		Input:   d.Input,
+		Output:  absPath(d.Output),
+		Status:  journal.StatusPending,
+		Started: time.Now(),
+	}
+	record := func() {
+		entry.Updated = time.Now()
+		if j != nil {
+			if err := j.Append(entry); err != nil {
+				log.Warn("Cannot update task journal: " + err.Error())
+			}
+		}
+		if onUpdate != nil {
+			onUpdate(entry)
+		}
+	}
+	fail := func(err error) (journal.Entry, error) {
+		entry.Status = journal.StatusFailed
+		if err == odm.ErrCanceled {
+			entry.Status = journal.StatusCanceled
+		}
+		entry.Error = err.Error()
+		record()
+		runHook("on-failure", d.OnFailure, entry, log)
+		return entry, err
+	}
+	record()
+
+	if err := checkOutputDirectory(d.Output, d.OutputMode); err != nil {
+		return fail(err)
+	}
+
+	candidates, err := candidateNodes(user, d.Node, len(d.Files), log)
+	if err != nil {
+		return fail(err)
+	}
+
+	taskOutputPath := ""
+	for i, name := range candidates {
+		if ctx.Err() != nil {
+			return fail(odm.ErrCanceled)
+		}
+
+		// Until the task is created, errors are specific to
+		// this node: try the next candidate if there is one
+		tryNext := func(err error) bool {
+			if i == len(candidates)-1 {
+				return false
+			}
+			log.Warn("Cannot use " + name + " (" + err.Error() + "), trying " + candidates[i+1] + "...")
+			return true
+		}
+
+		authMutex.Lock()
+		info, err := user.Authenticate(name, "", "")
+		authMutex.Unlock()
+		if err != nil {
+			if tryNext(err) {
+				continue
+			}
+			return fail(err)
+		}
+
+		// Check max images
+		if len(d.Files) > info.MaxImages {
+			err := errors.New("Cannot process " + strconv.Itoa(len(d.Files)) + " files with this node, the node has a limit of " + strconv.Itoa(info.MaxImages))
+			if tryNext(err) {
+				continue
+			}
+			return fail(err)
+		}
+
+		log.Trace("NodeODM version: " + info.Version)
+
+		node, err := user.GetNode(name)
+		if err != nil {
+			return fail(err)
+		}
+
+		nodeOptions, err := node.Options()
+		if err != nil {
+			if tryNext(err) {
+				continue
+			}
+			return fail(err)
+		}
+
+		taskOptions, err := parseOptions(d.Options, nodeOptions)
+		if err != nil {
+			return fail(err)
+		}
+		if d.Split > 0 {
+			taskOptions, err = addSplitOptions(taskOptions, nodeOptions, d.Split, d.SplitOverlap, info.IsClusterODM() || node.HasTag(odm.ClusterTag), log)
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		// Create output directory
+		if taskOutputPath == "" {
+			taskOutputPath, err = prepareOutputDirectory(d.Output, d.OutputMode)
+			if err != nil {
+				return fail(err)
+			}
+			entry.Output = absPath(taskOutputPath)
+		}
+
+		entry.Node = name
+		entry.NodeURL = node.URL
+		runSettings := settings
+		runSettings.Reauthenticate = user.Reauthenticator(name)
+		runSettings.Notifiers = user.GetNotifiers()
+		runSettings.TaskName = taskName
+		runSettings.DateCreated = d.DateCreated
+		runSettings.CleanOutput = d.OutputMode == outputModeClean
+		runSettings.OnStage = func(stage odm.Stage, uuid string) {
+			entry.Status = string(stage)
+			entry.UUID = uuid
+			record()
+		}
+
+		result, err := odm.Run(ctx, d.Files, taskOptions, *node, taskOutputPath, runSettings)
+		if err == nil {
+			break
+		}
+		if result.UUID != "" || err == odm.ErrCanceled || !tryNext(err) {
+			return fail(err)
+		}
+	}
+
+	if d.OutputMode == outputModeTimestamped {
+		if err := updateLatestLink(taskOutputPath); err != nil {
+			log.Warn("Cannot update " + latestLinkName + " link: " + err.Error())
+		}
+	}
+
+	entry.Status = journal.StatusCompleted
+	entry.Error = ""
+	record()
+	runHook("on-complete", d.OnComplete, entry, log)
+
+	return entry, nil
+}
+
+// absPath returns the absolute path of p, or p if it cannot be determined
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,99 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+	"github.com/spf13/cobra"
+)
+
+var publicNodeName string
+
+var publicCmd = &cobra.Command{
+	Use:   "public",
+	Short: "Browse and use public processing nodes",
+}
+
+var publicListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List public processing nodes",
+	Aliases: []string{"ls"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nodes := config.GetPublicNodes()
+
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "#\tURL\tMAINTAINER\tCOMPANY\tWEBSITE")
+		for i, n := range nodes {
+			fmt.Fprintln(w, strconv.Itoa(i+1)+"\t"+n.Url+"\t"+n.Maintainer+"\t"+n.Company+"\t"+n.Website)
+		}
+		w.Flush()
+
+		logger.Info(buf.String())
+	},
+}
+
+var publicUseCmd = &cobra.Command{
+	Use:   "use <#|url>",
+	Short: "Use a public processing node (replaces the node with the same name)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+		if config.IsNodeGroup(publicNodeName) {
+			logger.Error(publicNodeName + " is a reserved node name")
+		}
+		nodes := config.GetPublicNodes()
+
+		var selected *config.PublicNode
+		if i, err := strconv.Atoi(args[0]); err == nil {
+			if i >= 1 && i <= len(nodes) {
+				selected = &nodes[i-1]
+			}
+		} else {
+			for i := range nodes {
+				if nodes[i].Url == args[0] {
+					selected = &nodes[i]
+				}
+			}
+		}
+
+		if selected == nil {
+			logger.Error(args[0] + " is not a public node. See ./odm node public list")
+		}
+
+		// Settings of the previous node (transport, tags, ...) don't apply
+		if err := user.ReplaceNode(publicNodeName, odm.Node{URL: selected.Url}); err != nil {
+			logger.Error(err)
+		}
+
+		logger.Info("Node " + publicNodeName + " set to " + selected.String())
+	},
+}
+
+func init() {
+	publicUseCmd.Flags().StringVar(&publicNodeName, "name", "default", "name of the node to set")
+
+	publicCmd.AddCommand(publicListCmd)
+	publicCmd.AddCommand(publicUseCmd)
+	nodeCmd.AddCommand(publicCmd)
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	odmio "github.com/OpenDroneMap/CloudODM/internal/io"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+	"github.com/spf13/cobra"
+)
+
+var email string
+
+var registerCmd = &cobra.Command{
+	Use:   "register [--node default]",
+	Short: "Create an account with a node",
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		node, err := user.GetNode(nodeName)
+		if err != nil {
+			logger.Error(err)
+		}
+
+		res, err := node.AuthInfo()
+		if err != nil {
+			logger.Error(err)
+		}
+		if res.RegisterUrl == "" {
+			logger.Error("This node does not support registration")
+		}
+
+		if res.Message != "" {
+			logger.Info(res.Message)
+			logger.Info("")
+		}
+
+		prompt := email == "" || username == "" || password == ""
+		if prompt && !odmio.IsInteractive() {
+			logger.Error("Pass --email, --username and --password to register non-interactively")
+		}
+
+		for {
+			if prompt {
+				email, username, password = odmio.GetRegistrationInfo()
+			}
+
+			err = node.Register(res.RegisterUrl, email, username, password)
+			if err == nil {
+				break
+			}
+
+			regErr, ok := err.(*odm.RegistrationError)
+			if !ok || !prompt {
+				logger.Error(err)
+			}
+
+			logger.Info("")
+			for _, m := range regErr.Messages {
+				logger.Info(" * " + m)
+			}
+			logger.Info("Please try again.")
+			logger.Info("")
+		}
+
+		logger.Info("Account created")
+
+		if _, err := user.Login(nodeName, username, password); err != nil {
+			logger.Error(err)
+		}
+
+		logger.Info("Logged in")
+	},
+}
+
+func init() {
+	registerCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "Processing node to use")
+	registerCmd.Flags().StringVar(&email, "email", "", "Email")
+	registerCmd.Flags().StringVar(&username, "username", "", "Username")
+	registerCmd.Flags().StringVar(&password, "password", "", "Password")
+
+	rootCmd.AddCommand(registerCmd)
+}
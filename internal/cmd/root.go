@@ -16,11 +16,16 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"mime"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/OpenDroneMap/CloudODM/internal/config"
 	"github.com/OpenDroneMap/CloudODM/internal/fs"
@@ -33,13 +38,44 @@ import (
 var outputPath string
 var nodeName string
 var force bool
-var parallelConnections int
+var outputMode string
+var parallelConnections string
+var uploadConnections int
+var autoConnections bool
 var maxUploadRetries int
+var split int
+var splitOverlap int
+var taskName string
+var dateCreated string
+var postProcessing bool
+var extraAssets []string
+
+var verbose, debug, quiet bool
+var logLevel string
+var outputFormat string
+var color string
+var timestamps bool
 
 var rootCmd = &cobra.Command{
 	Use:     "odm [flags] <images> [<gcp>] [args]",
 	Short:   "A command line tool to process aerial imagery in the cloud",
 	Version: "1.1.1",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := configureLogger(cmd); err != nil {
+			logger.Error(err)
+		}
+		if cmd.Flags().Lookup("parallel-connections") != nil {
+			var err error
+			if uploadConnections, autoConnections, err = parseParallelConnections(parallelConnections); err != nil {
+				logger.Error(err)
+			}
+		}
+		if metricsAddr != "" {
+			if err := startMetricsServer(metricsAddr); err != nil {
+				logger.Error(err)
+			}
+		}
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		user := config.Initialize()
 		if len(args) == 0 {
@@ -48,52 +84,66 @@ var rootCmd = &cobra.Command{
 		}
 
 		// Check output directory
-		filesCount, err := fs.DirectoryFilesCount(outputPath)
-		if err != nil {
-			logger.Error(err)
+		if force && !cmd.Flags().Changed("output-mode") {
+			outputMode = outputModeOverwrite
 		}
-		if filesCount > 0 && !force {
-			logger.Error(outputPath + " already exists (pass --force to override directory contents)")
+		if err := checkOutputDirectory(outputPath, outputMode); err != nil {
+			logger.Error(err)
 		}
 
 		inputFiles, options := parseArgs(args)
 		inputFiles = filterImagesAndText(inputFiles)
 
-		logger.Verbose("Input Files (" + strconv.Itoa(len(inputFiles)) + ")")
+		logger.Debug("Input Files (" + strconv.Itoa(len(inputFiles)) + ")")
 		for _, file := range inputFiles {
-			logger.Debug(" * " + file)
+			logger.Trace(" * " + file)
 		}
 
-		logger.Debug("Options: " + strings.Join(options, " "))
+		logger.Trace("Options: " + strings.Join(options, " "))
 
-		info := user.CheckLogin(nodeName, "", "")
-
-		// Check max images
-		if len(inputFiles) > info.MaxImages {
-			logger.Error("Cannot process", len(inputFiles), "files with this node, the node has a limit of", info.MaxImages)
-		}
-
-		logger.Debug("NodeODM version: " + info.Version)
-
-		node, err := user.GetNode(nodeName)
+		created, err := parseDate(dateCreated)
 		if err != nil {
 			logger.Error(err)
 		}
-
-		nodeOptions, err := node.Options()
-		if err != nil {
+		if taskName == "" {
+			taskName = defaultTaskName(args)
+		}
+		if err := checkAssets(extraAssets); err != nil {
 			logger.Error(err)
 		}
-
-		// Create output directory
-		if !fs.IsDirectory(outputPath) {
-			err = os.MkdirAll(outputPath, 0755)
-			if err != nil {
-				logger.Error(err)
-			}
+		if len(extraAssets) > 0 && !postProcessing {
+			logger.Debug("Enabling post processing to generate " + strings.Join(extraAssets, ", "))
+			postProcessing = true
 		}
 
-		odm.Run(inputFiles, parseOptions(options, nodeOptions), *node, outputPath, parallelConnections, maxUploadRetries)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		_, err = processDataset(ctx, user, openJournal(), dataset{
+			TaskName:     taskName,
+			DateCreated:  created,
+			Input:        strings.Join(inputArgs(args), " "),
+			Files:        inputFiles,
+			Options:      options,
+			Node:         nodeName,
+			Output:       outputPath,
+			OutputMode:   outputMode,
+			Split:        split,
+			SplitOverlap: splitOverlap,
+			OnComplete:   onComplete,
+			OnFailure:    onFailure,
+		}, odm.RunSettings{
+			ParallelConnections: uploadConnections,
+			AutoConnections:     autoConnections,
+			MaxUploadRetries:    maxUploadRetries,
+			Webhook:             webhook,
+			PostProcessing:      postProcessing,
+			Assets:              extraAssets,
+		}, nil)
+		printMetricsSummary()
+		if err != nil {
+			logger.Error(err)
+		}
 	},
 
 	TraverseChildren: true,
@@ -110,19 +160,63 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().BoolVarP(&logger.VerboseFlag, "verbose", "v", false, "show verbose output")
-	rootCmd.PersistentFlags().BoolVarP(&logger.DebugFlag, "debug", "d", false, "show debug output")
-	rootCmd.PersistentFlags().BoolVarP(&logger.QuietFlag, "quiet", "q", false, "suppress output")
-
-	rootCmd.Flags().BoolVarP(&force, "force", "f", false, "replace the contents of the output directory if it already exists")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "show verbose output (same as --log-level debug)")
+	rootCmd.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "show debug output (same as --log-level trace)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "suppress output except errors (same as --log-level error)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level: trace, debug, info, warn or error (defaults to $"+logger.LevelEnv+" or info)")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", "text", "output format: text or json (one JSON event per line, for scripts)")
+	rootCmd.PersistentFlags().StringVar(&color, "color", "auto", "color log levels: auto, always or never")
+	rootCmd.PersistentFlags().BoolVar(&timestamps, "timestamps", false, "prefix messages with the current time")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "expose Prometheus metrics at /metrics on this address (e.g. 127.0.0.1:9090) instead of printing a summary when processing finishes")
+
+	rootCmd.Flags().BoolVarP(&force, "force", "f", false, "replace the contents of the output directory if it already exists (same as --output-mode overwrite)")
+	rootCmd.Flags().StringVar(&outputMode, "output-mode", outputModeNew, "how to handle an existing output directory: new (fail if not empty), overwrite (extract over existing files), clean (empty it first), timestamped (create a new timestamped subdirectory and update the \"latest\" link)")
 	rootCmd.Flags().StringVarP(&outputPath, "output", "o", "./output", "directory where to store processing results")
-	rootCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "Processing node to use")
-	rootCmd.Flags().IntVarP(&parallelConnections, "parallel-connections", "p", 5, "Parallel upload connections. Set to 1 to disable parallel uploads")
+	rootCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "Processing node to use (\"auto\" picks the least busy node, \"@tag\" the least busy node with a tag)")
+	rootCmd.Flags().StringVarP(&parallelConnections, "parallel-connections", "p", "5", "Parallel upload connections. Set to 1 to disable parallel uploads, or to \"auto\" to adjust them to the measured throughput")
 	rootCmd.Flags().IntVarP(&maxUploadRetries, "max-upload-retries", "m", 10, "Max retries before giving up on a file upload when using parallel upload connections.")
+	addHookFlags(rootCmd)
+
+	rootCmd.Flags().StringVar(&taskName, "name", "", "name of the task displayed by the node (defaults to the name of the images directory)")
+	rootCmd.Flags().StringVar(&dateCreated, "dateCreated", "", "creation date of the task displayed by the node, e.g. the date of the flight (YYYY-MM-DD, RFC 3339 or milliseconds since the epoch)")
+
+	rootCmd.Flags().BoolVar(&postProcessing, "post-processing", false, "let the node generate tiles and web point clouds after processing (slower)")
+	rootCmd.Flags().StringSliceVar(&extraAssets, "assets", nil, "additional assets to download and extract, generated by post processing: "+strings.Join(odm.PostProcessingAssets, ", ")+" (implies --post-processing)")
+
+	rootCmd.Flags().IntVar(&split, "split", 0, "split the dataset into submodels of approximately this many images (split-merge, best used with ClusterODM)")
+	rootCmd.Flags().IntVar(&splitOverlap, "split-overlap", 0, "radius of the overlap between submodels in meters when using --split (0 uses the node default)")
 
 	rootCmd.Flags().SetInterspersed(false)
 }
 
+// configureLogger sets up the logger from the command line flags
+func configureLogger(cmd *cobra.Command) error {
+	level, err := logger.LevelFromEnv(logger.InfoLevel)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cmd.Flags().Changed("log-level"):
+		if level, err = logger.ParseLevel(logLevel); err != nil {
+			return err
+		}
+	case debug:
+		level = logger.TraceLevel
+	case verbose:
+		level = logger.DebugLevel
+	case quiet:
+		level = logger.ErrorLevel
+	}
+
+	return logger.Configure(logger.Settings{
+		Level:      level,
+		Format:     outputFormat,
+		Color:      color,
+		Timestamps: timestamps,
+	})
+}
+
 func parseArgs(args []string) ([]string, []string) {
 	var inputFiles []string
 	var options []string
@@ -150,6 +244,18 @@ func parseArgs(args []string) ([]string, []string) {
 	return inputFiles, options
 }
 
+// inputArgs returns the absolute paths of the arguments
+// that are input files or directories
+func inputArgs(args []string) []string {
+	result := []string{}
+	for _, arg := range args {
+		if fs.IsDirectory(arg) || fs.IsFile(arg) {
+			result = append(result, absPath(arg))
+		}
+	}
+	return result
+}
+
 func filterImagesAndText(files []string) []string {
 	var result []string
 
@@ -163,11 +269,135 @@ func filterImagesAndText(files []string) []string {
 	return result
 }
 
-func invalidArg(arg string) {
-	logger.Error("Invalid argument " + arg + ". See ./odm args for a list of valid arguments.")
+// candidateNodes returns the names of the nodes to try, in order. When
+// nodeName is "auto" or a @tag group, the nodes are queried and ranked.
+func candidateNodes(user config.Configuration, nodeName string, imagesCount int, log *logger.Logger) ([]string, error) {
+	if !config.IsNodeGroup(nodeName) {
+		return []string{nodeName}, nil
+	}
+
+	members, err := user.GroupMembers(nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("Querying " + strconv.Itoa(len(members)) + " nodes...")
+
+	candidates := []string{}
+	for _, s := range user.SelectNodes(members, imagesCount) {
+		log.Debug(" * " + s.Name + ": " + strconv.Itoa(s.Info.TaskQueueCount) + " tasks in queue, " +
+			strconv.Itoa(s.Info.FreeSlots()) + " free slots, " + s.Latency.Round(time.Millisecond).String())
+		candidates = append(candidates, s.Name)
+	}
+
+	if len(candidates) == 0 {
+		return nil, errors.New("No available node can process " + strconv.Itoa(imagesCount) + " images")
+	}
+
+	log.Info("Selected node: " + candidates[0])
+
+	return candidates, nil
 }
 
-func parseOptions(options []string, nodeOptions []odm.OptionResponse) []odm.Option {
+// addSplitOptions validates split and splitOverlap (--split and
+// --split-overlap) against the options supported by the node and adds
+// them to the task options
+func addSplitOptions(taskOptions []odm.Option, nodeOptions []odm.OptionResponse, split int, splitOverlap int, isCluster bool, log *logger.Logger) ([]odm.Option, error) {
+	splitOptions := []odm.Option{{Name: "split", Value: strconv.Itoa(split)}}
+	if splitOverlap > 0 {
+		splitOptions = append(splitOptions, odm.Option{Name: "split-overlap", Value: strconv.Itoa(splitOverlap)})
+	}
+
+	for _, so := range splitOptions {
+		found := false
+		for _, no := range nodeOptions {
+			if no.Name == so.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("This node does not support the " + so.Name + " option (is it running an old version of ODM?)")
+		}
+	}
+
+	if isCluster {
+		log.Debug("ClusterODM detected, submodels will be distributed across its nodes")
+	} else {
+		log.Warn("This node does not appear to be a ClusterODM instance, submodels will be processed sequentially on a single node")
+	}
+
+	// Replace any split options passed as arguments
+	result := []odm.Option{}
+	for _, o := range taskOptions {
+		if o.Name != "split" && (o.Name != "split-overlap" || splitOverlap == 0) {
+			result = append(result, o)
+		}
+	}
+
+	return append(result, splitOptions...), nil
+}
+
+// defaultTaskName returns the name of the directory of the first input
+func defaultTaskName(args []string) string {
+	for _, arg := range inputArgs(args) {
+		if fs.IsDirectory(arg) {
+			return filepath.Base(arg)
+		}
+		return filepath.Base(filepath.Dir(arg))
+	}
+	return ""
+}
+
+// parseDate parses a date (YYYY-MM-DD), a date and time (RFC 3339) or
+// milliseconds since the epoch. An empty string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid date " + s + " (use YYYY-MM-DD, RFC 3339 or milliseconds since the epoch)")
+}
+
+// checkAssets validates the names of assets to download
+func checkAssets(assets []string) error {
+	for _, asset := range assets {
+		found := false
+		for _, valid := range odm.PostProcessingAssets {
+			found = found || asset == valid
+		}
+		if !found {
+			return errors.New("Invalid asset " + asset + " (valid assets are: " + strings.Join(odm.PostProcessingAssets, ", ") + ")")
+		}
+	}
+	return nil
+}
+
+// parseParallelConnections parses the number of upload connections,
+// where "auto" means the maximum number of tuned connections
+func parseParallelConnections(s string) (int, bool, error) {
+	if s == "auto" {
+		return odm.MaxAutoConnections, true, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, false, errors.New("Invalid number of parallel connections " + s + " (use a number or auto)")
+	}
+	return n, false, nil
+}
+
+func invalidArg(arg string) error {
+	return errors.New("Invalid argument " + arg + ". See ./odm args for a list of valid arguments.")
+}
+
+func parseOptions(options []string, nodeOptions []odm.OptionResponse) ([]odm.Option, error) {
 	result := []odm.Option{}
 
 	for i := 0; i < len(options); i++ {
@@ -191,7 +421,7 @@ func parseOptions(options []string, nodeOptions []odm.OptionResponse) []odm.Opti
 			}
 
 			if !found {
-				invalidArg(o)
+				return nil, invalidArg(o)
 			}
 
 			// TODO: domain checks
@@ -204,15 +434,15 @@ func parseOptions(options []string, nodeOptions []odm.OptionResponse) []odm.Opti
 					currentOption.Value = options[i+1]
 					i++
 				} else {
-					invalidArg(o)
+					return nil, invalidArg(o)
 				}
 			}
 
 			result = append(result, currentOption)
 		} else {
-			invalidArg(o)
+			return nil, invalidArg(o)
 		}
 	}
 
-	return result
+	return result, nil
 }
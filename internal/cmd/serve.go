@@ -0,0 +1,636 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/batch"
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/fs"
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/metrics"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+
+	"github.com/spf13/cobra"
+)
+
+var listenAddr string
+var uploadsDir string
+var maxUploadSize int
+
+// finishedJobsRetention is how long finished jobs are kept in memory,
+// afterwards they are read from the journal
+const finishedJobsRetention = time.Hour
+
+var errServerStopping = errors.New("The server is stopping")
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a local REST API to submit and manage processing jobs",
+	Long: `Run a local REST API to submit and manage processing jobs.
+
+Endpoints:
+
+  POST /jobs                    submit a job, either as JSON
+                                {"images": "/path", "node": "auto", "options": "--dsm", "preset": "fast"}
+                                or as multipart/form-data with "images" files
+                                and optional node, options and preset fields
+  GET  /jobs                    list jobs (including tasks run with odm)
+  GET  /jobs/<id>               get a job
+  GET  /jobs/<id>/logs          stream the task output (server-sent events)
+  POST /jobs/<id>/cancel        cancel a job
+  GET  /jobs/<id>/results/...   download the results
+  GET  /metrics                 Prometheus metrics
+
+Results are saved to <output>/<id>. Uploads are limited to
+--max-upload-size MB per job. The API has no authentication:
+only listen on addresses reachable by trusted clients.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		if uploadsDir == "" {
+			uploadsDir = filepath.Join(outputPath, ".uploads")
+		}
+
+		// Progress bars of different jobs would overlap
+		logger.DisableProgress()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		s := &server{
+			ctx:       ctx,
+			user:      user,
+			journal:   openJournal(),
+			output:    absPath(outputPath),
+			uploads:   uploadsDir,
+			maxUpload: int64(maxUploadSize) << 20,
+			retention: finishedJobsRetention,
+			slots:     make(chan bool, concurrency),
+			jobs:      map[string]*job{},
+		}
+		httpServer := &http.Server{Addr: listenAddr, Handler: s}
+
+		go func() {
+			<-ctx.Done()
+			logger.Info("Stopping...")
+			s.mu.Lock()
+			s.closed = true
+			s.mu.Unlock()
+			s.wg.Wait()
+			httpServer.Close()
+		}()
+
+		logger.Info("Listening on " + listenAddr)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(err)
+		}
+		s.wg.Wait()
+		printMetricsSummary()
+	},
+}
+
+// server manages the jobs submitted through the REST API
+type server struct {
+	ctx     context.Context
+	user    config.Configuration
+	journal *journal.Journal
+	output  string
+	uploads string
+	slots   chan bool
+	wg      sync.WaitGroup
+
+	// maxUpload is the maximum size (bytes) of a multipart request
+	maxUpload int64
+
+	// retention is how long finished jobs are kept in jobs
+	retention time.Duration
+
+	mu     sync.Mutex
+	jobs   map[string]*job
+	closed bool // no new jobs are accepted once the server is stopping
+}
+
+// job is a dataset submitted to the server
+type job struct {
+	mu     sync.Mutex
+	entry  journal.Entry
+	cancel context.CancelFunc
+}
+
+func (j *job) Entry() journal.Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.entry
+}
+
+// jobRequest is the JSON body of POST /jobs
+type jobRequest struct {
+	Name    string `json:"name"`
+	Images  string `json:"images"`
+	Node    string `json:"node"`
+	Options string `json:"options"`
+	Preset  string `json:"preset"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 3)
+
+	switch {
+	case len(parts) == 1 && parts[0] == "metrics" && r.Method == http.MethodGet:
+		metrics.Default.Handler().ServeHTTP(w, r)
+	case parts[0] != "jobs":
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.listJobs(w, r)
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.submitJob(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.getJob(w, parts[1])
+	case len(parts) == 3 && parts[2] == "logs" && r.Method == http.MethodGet:
+		s.streamLogs(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "cancel" && r.Method == http.MethodPost:
+		s.cancelJob(w, parts[1])
+	case len(parts) == 3 && strings.HasPrefix(parts[2], "results") && r.Method == http.MethodGet:
+		s.serveResults(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, errors.New("Not found"))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// entry returns the latest state of a job, from memory or the journal
+func (s *server) entry(id string) (journal.Entry, bool) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if ok {
+		return j.Entry(), true
+	}
+
+	if s.journal != nil {
+		if e, ok := s.journal.Get(id); ok {
+			return *e, true
+		}
+	}
+	return journal.Entry{}, false
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	entries := []journal.Entry{}
+	if s.journal != nil {
+		var err error
+		if entries, err = s.journal.Entries(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Jobs waiting for a free slot are not in the journal yet
+	listed := map[string]bool{}
+	for i, e := range entries {
+		if j, ok := s.jobs[e.ID]; ok {
+			entries[i] = j.Entry()
+		}
+		listed[e.ID] = true
+	}
+	for id, j := range s.jobs {
+		if !listed[id] {
+			entries = append(entries, j.Entry())
+		}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *server) getJob(w http.ResponseWriter, id string) {
+	e, ok := s.entry(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("Job "+id+" not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) {
+	if s.ctx.Err() != nil {
+		writeError(w, http.StatusServiceUnavailable, errServerStopping)
+		return
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	req := jobRequest{}
+	files := []string{}
+	uploadDir := ""
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		tooLarge := errors.New("The upload exceeds the maximum size of " + strconv.FormatInt(s.maxUpload>>20, 10) + " MB")
+		if r.ContentLength > s.maxUpload {
+			writeError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+
+		uploadDir = filepath.Join(s.uploads, id)
+		files, err = saveUploads(r, uploadDir, &req)
+		if err != nil {
+			os.RemoveAll(uploadDir)
+			status := http.StatusBadRequest
+			if isMaxBytesError(err) {
+				status, err = http.StatusRequestEntityTooLarge, tooLarge
+			}
+			writeError(w, status, err)
+			return
+		}
+		req.Images = uploadDir
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("Invalid request: "+err.Error()))
+			return
+		}
+		if !fs.IsDirectory(req.Images) {
+			writeError(w, http.StatusBadRequest, errors.New("Images must be a directory on the server"))
+			return
+		}
+		files, _ = parseArgs([]string{req.Images})
+		files = filterImagesAndText(files)
+	}
+
+	if len(files) == 0 {
+		os.RemoveAll(uploadDir)
+		writeError(w, http.StatusBadRequest, errors.New("No images found"))
+		return
+	}
+
+	d, err := s.jobDataset(id, req, files)
+	if err != nil {
+		os.RemoveAll(uploadDir)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	j := &job{
+		entry: journal.Entry{
+			ID:      id,
+			Name:    d.TaskName,
+			Input:   d.Input,
+			Output:  absPath(d.Output),
+			Status:  journal.StatusPending,
+			Started: time.Now(),
+			Updated: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		os.RemoveAll(uploadDir)
+		writeError(w, http.StatusServiceUnavailable, errServerStopping)
+		return
+	}
+	s.jobs[id] = j
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		if uploadDir != "" {
+			defer os.RemoveAll(uploadDir)
+		}
+		s.runJob(ctx, j, d)
+
+		// Keep the job for a while, then read it from the journal
+		time.AfterFunc(s.retention, func() {
+			s.mu.Lock()
+			delete(s.jobs, id)
+			s.mu.Unlock()
+		})
+	}()
+
+	writeJSON(w, http.StatusCreated, j.Entry())
+}
+
+// jobDataset creates the dataset of a job from a request
+func (s *server) jobDataset(id string, req jobRequest, files []string) (dataset, error) {
+	d := dataset{
+		ID:         id,
+		Name:       req.Name,
+		TaskName:   req.Name,
+		Input:      absPath(req.Images),
+		Files:      files,
+		Node:       req.Node,
+		Output:     filepath.Join(s.output, id),
+		OutputMode: outputModeNew,
+		OnComplete: onComplete,
+		OnFailure:  onFailure,
+	}
+	if d.Name == "" {
+		d.Name = id
+	}
+	options, err := batch.SplitOptions(req.Options)
+	if err != nil {
+		return d, err
+	}
+	d.Options = options
+	if d.TaskName == "" {
+		// Uploaded images are saved in a directory named after the job
+		d.TaskName = filepath.Base(d.Input)
+	}
+
+	if req.Preset != "" {
+		preset, err := s.user.GetPreset(req.Preset)
+		if err != nil {
+			return d, err
+		}
+		d.Options = append(append([]string{}, preset.Options...), d.Options...)
+		if d.Node == "" {
+			d.Node = preset.Node
+		}
+	}
+	if d.Node == "" {
+		d.Node = nodeName
+	}
+
+	return d, nil
+}
+
+// saveUploads saves the images of a multipart request to dir
+// and reads the other fields into req
+func saveUploads(r *http.Request, dir string, req *jobRequest) ([]string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "images" && part.FileName() != "" {
+			file := filepath.Join(dir, filepath.Base(part.FileName()))
+			f, err := os.Create(file)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, part)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, file)
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 64*1024))
+		if err != nil {
+			return nil, err
+		}
+		switch part.FormName() {
+		case "name":
+			req.Name = string(value)
+		case "node":
+			req.Node = string(value)
+		case "options":
+			req.Options = string(value)
+		case "preset":
+			req.Preset = string(value)
+		}
+	}
+
+	return filterImagesAndText(files), nil
+}
+
+// isMaxBytesError checks whether err comes from a body limited with
+// http.MaxBytesReader that was larger than allowed
+func isMaxBytesError(err error) bool {
+	return strings.Contains(err.Error(), "http: request body too large")
+}
+
+func (s *server) runJob(ctx context.Context, j *job, d dataset) {
+	log := logger.With(logger.Fields{"job": d.ID})
+
+	select {
+	case s.slots <- true:
+	case <-ctx.Done():
+		j.mu.Lock()
+		j.entry.Status = journal.StatusCanceled
+		j.entry.Updated = time.Now()
+		j.mu.Unlock()
+		return
+	}
+	defer func() { <-s.slots }()
+
+	entry, err := processDataset(ctx, s.user, s.journal, d, odm.RunSettings{
+		ParallelConnections: uploadConnections,
+		AutoConnections:     autoConnections,
+		MaxUploadRetries:    maxUploadRetries,
+		Webhook:             webhook,
+
+		// Only warnings and errors, the task output can be streamed
+		Logger: log.WithLevel(logger.WarnLevel),
+	}, func(e journal.Entry) {
+		j.mu.Lock()
+		changed := e.Status != j.entry.Status
+		j.entry = e
+		j.mu.Unlock()
+
+		if changed && !e.Done() {
+			log.Event("job_status", d.Name+": "+e.Status, logger.Fields{"status": e.Status, "node": e.Node, "uuid": e.UUID})
+		}
+	})
+
+	if err != nil {
+		log.Warn(err)
+	} else {
+		logger.Info(d.Name + ": completed, results saved in " + entry.Output)
+	}
+	log.Event("job_result", "", logger.Fields{"status": entry.Status, "error": entry.Error})
+}
+
+func (s *server) cancelJob(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("Job "+id+" is not running on this server"))
+		return
+	}
+
+	j.cancel()
+	writeJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// streamLogs sends the task output of a job as server-sent events
+// ("log" events with a line, "status" events with the job) until
+// the job is done or the client disconnects. Only the jobs running
+// on this server are followed, for the others the output saved so
+// far is sent.
+func (s *server) streamLogs(w http.ResponseWriter, r *http.Request, id string) {
+	e, ok := s.entry(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("Job "+id+" not found"))
+		return
+	}
+
+	s.mu.Lock()
+	j := s.jobs[id]
+	s.mu.Unlock()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sendEvent := func(event string, data string) {
+		fmt.Fprint(w, "event: "+event+"\ndata: "+data+"\n\n")
+		flusher.Flush()
+	}
+	sendStatus := func(e journal.Entry) {
+		data, _ := json.Marshal(e)
+		sendEvent("status", string(data))
+	}
+
+	var offset int64
+	sendLines := func(e journal.Entry) {
+		f, err := os.Open(filepath.Join(e.Output, "task_output.log"))
+		if err != nil {
+			return
+		}
+		defer f.Close()
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+		reader := bufio.NewReader(f)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				// Partial lines are sent once they are complete
+				return
+			}
+			offset += int64(len(line))
+			sendEvent("log", strings.TrimSuffix(line, "\n"))
+		}
+	}
+
+	sendStatus(e)
+	status := e.Status
+	for {
+		sendLines(e)
+		if e.Done() || j == nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+
+		e = j.Entry()
+		if e.Status != status {
+			status = e.Status
+			sendStatus(e)
+		}
+	}
+}
+
+func (s *server) serveResults(w http.ResponseWriter, r *http.Request, id string) {
+	e, ok := s.entry(id)
+	if !ok || e.Output == "" {
+		writeError(w, http.StatusNotFound, errors.New("Job "+id+" not found"))
+		return
+	}
+
+	// The journal also has tasks run with odm, don't
+	// serve files outside of the output directory
+	if rel, err := filepath.Rel(s.output, e.Output); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		writeError(w, http.StatusNotFound, errors.New("The results of job "+id+" are not available from this server"))
+		return
+	}
+
+	prefix := "/jobs/" + id + "/results"
+	http.StripPrefix(prefix, http.FileServer(http.Dir(e.Output))).ServeHTTP(w, r)
+}
+
+func init() {
+	serveCmd.Flags().StringVarP(&listenAddr, "listen", "l", "127.0.0.1:8080", "address to listen on")
+	serveCmd.Flags().StringVarP(&outputPath, "output", "o", "./output", "directory where to store the results of jobs")
+	serveCmd.Flags().StringVar(&uploadsDir, "uploads-dir", "", "directory where to store uploaded images until they are processed (default <output>/.uploads)")
+	serveCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "processing node to use for jobs without a node (\"auto\" picks the least busy node, \"@tag\" the least busy node with a tag)")
+	serveCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 2, "number of jobs to process at the same time")
+	serveCmd.Flags().IntVar(&maxUploadSize, "max-upload-size", 20480, "maximum size in MB of the images uploaded with a job")
+	serveCmd.Flags().StringVarP(&parallelConnections, "parallel-connections", "p", "5", "parallel upload connections per job. Set to 1 to disable parallel uploads, or to \"auto\" to adjust them to the measured throughput")
+	serveCmd.Flags().IntVarP(&maxUploadRetries, "max-upload-retries", "m", 10, "max retries before giving up on a file upload when using parallel upload connections")
+	addHookFlags(serveCmd)
+
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,246 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+)
+
+// newTestNode returns a fake NodeODM that completes every task after
+// reporting it as running once, with two lines of output
+func newTestNode(t *testing.T) *httptest.Server {
+	var results bytes.Buffer
+	zw := zip.NewWriter(&results)
+	f, _ := zw.Create("odm_orthophoto/odm_orthophoto.tif")
+	f.Write([]byte("tif"))
+	zw.Close()
+
+	polls := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/info":
+			w.Write([]byte(`{"version":"2.2.0","maxImages":100}`))
+		case r.URL.Path == "/options":
+			w.Write([]byte(`[{"name":"dsm","type":"bool","value":"false"}]`))
+		case r.URL.Path == "/task/new":
+			io.Copy(ioutil.Discard, r.Body)
+			w.Write([]byte(`{"uuid":"1234"}`))
+		case r.URL.Path == "/task/1234/info":
+			polls++
+			code := 20
+			if polls > 1 {
+				code = 40
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"uuid": "1234", "status": map[string]int{"code": code}})
+		case r.URL.Path == "/task/1234/output":
+			w.Write([]byte(`["line 1","line 2"]`))
+		case r.URL.Path == "/task/1234/download/all.zip":
+			w.Header().Set("Content-Type", "application/zip")
+			w.Write(results.Bytes())
+		default:
+			t.Log("Unexpected request:", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func newTestServer(t *testing.T, dir string, nodeURL string) *server {
+	user := config.NewConfiguration(filepath.Join(dir, "odm.json"))
+	if err := user.AddNode("default", nodeURL); err != nil {
+		t.Fatal(err)
+	}
+
+	return &server{
+		ctx:       context.Background(),
+		user:      user,
+		journal:   journal.Open(filepath.Join(dir, "tasks.jsonl")),
+		output:    filepath.Join(dir, "output"),
+		uploads:   filepath.Join(dir, "uploads"),
+		maxUpload: 1 << 20,
+		retention: 10 * time.Millisecond,
+		slots:     make(chan bool, 1),
+		jobs:      map[string]*job{},
+	}
+}
+
+func TestServeJob(t *testing.T) {
+	logger.DisableProgress()
+	nodeName = "default"
+
+	dir, err := ioutil.TempDir("", "odm-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	images := filepath.Join(dir, "images")
+	os.Mkdir(images, 0755)
+	ioutil.WriteFile(filepath.Join(images, "1.jpg"), []byte("jpg"), 0644)
+
+	node := newTestNode(t)
+	defer node.Close()
+	s := newTestServer(t, dir, node.URL)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// Submit
+	if resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"images": "`+filepath.Join(dir, "missing")+`"}`)); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Error("Jobs without images should be rejected")
+	}
+
+	if resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"images": "`+images+`", "options": "--dsm '"}`)); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Error("Jobs with invalid options should be rejected")
+	}
+
+	resp, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"images": "`+images+`", "options": "--dsm"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitted := journal.Entry{}
+	json.NewDecoder(resp.Body).Decode(&submitted)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || submitted.ID == "" {
+		t.Fatal("Cannot submit job, status", resp.StatusCode)
+	}
+	s.wg.Wait()
+
+	// Status
+	resp, err = http.Get(ts.URL + "/jobs/" + submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := journal.Entry{}
+	json.NewDecoder(resp.Body).Decode(&e)
+	resp.Body.Close()
+	if e.Status != journal.StatusCompleted || e.Output != filepath.Join(s.output, submitted.ID) {
+		t.Fatal("Job should have completed in the output directory:", e.Status, e.Error, e.Output)
+	}
+
+	// Finished jobs are read from the journal once they expire
+	count := 1
+	for i := 0; i < 100 && count > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		s.mu.Lock()
+		count = len(s.jobs)
+		s.mu.Unlock()
+	}
+	if count != 0 {
+		t.Error("Finished jobs should have been removed")
+	}
+	if e, ok := s.entry(submitted.ID); !ok || e.Status != journal.StatusCompleted {
+		t.Error("Expired job should have been read from the journal")
+	}
+
+	// Logs
+	resp, err = http.Get(ts.URL + "/jobs/" + submitted.ID + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(logs), "event: status\n") || !strings.Contains(string(logs), "event: log\ndata: ") || !strings.Contains(string(logs), " line 2\n\n") {
+		t.Error("Unexpected logs:", string(logs))
+	}
+
+	// Tasks that are not running on this server are not followed
+	s.journal.Append(journal.Entry{ID: "elsewhere", Output: filepath.Join(dir, "elsewhere"), Status: "processing"})
+	client := http.Client{Timeout: 5 * time.Second}
+	if resp, err = client.Get(ts.URL + "/jobs/elsewhere/logs"); err != nil {
+		t.Error("Logs of tasks running elsewhere should not be followed:", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// Results
+	resp, err = http.Get(ts.URL + "/jobs/" + submitted.ID + "/results/odm_orthophoto/odm_orthophoto.tif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "tif" {
+		t.Error("Cannot download results, status", resp.StatusCode)
+	}
+
+	// Tasks run outside of the output directory are not served
+	outside := filepath.Join(dir, "elsewhere")
+	os.Mkdir(outside, 0755)
+	ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	for i, output := range []string{outside, s.output, s.output + "-other"} {
+		id := "outside" + string(rune('a'+i))
+		s.journal.Append(journal.Entry{ID: id, Output: output, Status: journal.StatusCompleted})
+		resp, err = http.Get(ts.URL + "/jobs/" + id + "/results/secret.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Error("Results in", output, "should not be served, status", resp.StatusCode)
+		}
+	}
+}
+
+func TestServeUploadLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestServer(t, dir, "http://localhost:3000")
+	s.maxUpload = 1024
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// Without a content length, the limit is checked while reading
+	r, w := io.Pipe()
+	mpw := multipart.NewWriter(w)
+	go func() {
+		part, _ := mpw.CreateFormFile("images", "1.jpg")
+		part.Write(bytes.Repeat([]byte("x"), 4096))
+		w.CloseWithError(mpw.Close())
+	}()
+
+	resp, err := http.Post(ts.URL+"/jobs", mpw.FormDataContentType(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Error("Uploads larger than the limit should be rejected, status", resp.StatusCode)
+	}
+	if files, _ := ioutil.ReadDir(s.uploads); len(files) != 0 {
+		t.Error("Rejected uploads should have been removed")
+	}
+}
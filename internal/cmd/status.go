@@ -0,0 +1,187 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+	"github.com/spf13/cobra"
+)
+
+var watchStatus bool
+var watchInterval time.Duration
+
+var statusCmd = &cobra.Command{
+	Use:   "status [<name>...]",
+	Short: "Show the status of processing nodes",
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		names := args
+		if len(names) == 0 {
+			names = user.NodeNames()
+		}
+
+		for {
+			table := nodeStatusTable(user, names)
+			if watchStatus && !logger.JSON() {
+				fmt.Print("\033[H\033[2J")
+				logger.Info(time.Now().Format("2006-01-02 15:04:05") + " (refreshing every " + watchInterval.String() + ", press CTRL+C to exit)")
+				logger.Info("")
+			}
+			if !logger.JSON() {
+				logger.Info(table)
+			}
+
+			if !watchStatus {
+				break
+			}
+			time.Sleep(watchInterval)
+		}
+	},
+}
+
+type authStatus struct {
+	required bool
+	err      error
+}
+
+func nodeStatusTable(user config.Configuration, names []string) string {
+	statuses := user.ProbeNodes(names)
+
+	// Check which nodes require authentication
+	auth := make([]authStatus, len(statuses))
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			auth[i].required, auth[i].err = statuses[i].Node.AuthRequired()
+		}(i)
+	}
+	wg.Wait()
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tLATENCY\tVERSION\tENGINE\tQUEUE\tMAX IMAGES\tMEMORY\tCPU\tAUTH\tTOKEN")
+	clusters := false
+
+	for i, s := range statuses {
+		status := "online"
+		latency, version, engine, queue, maxImages, memory, cpu := "-", "-", "-", "-", "-", "-", "-"
+
+		if s.Online() {
+			latency = s.Latency.Round(time.Millisecond).String()
+			version = s.Info.Version
+			if s.Info.Engine != "" {
+				engine = s.Info.Engine + " " + s.Info.EngineVersion
+			}
+			if s.Info.IsClusterODM() || s.Node.HasTag(odm.ClusterTag) {
+				engine = "ClusterODM (" + engine + ")"
+				clusters = true
+			}
+			queue = strconv.Itoa(s.Info.TaskQueueCount) + "/" + strconv.Itoa(s.Info.MaxParallelTasks)
+			if s.Info.MaxImages == math.MaxInt32 {
+				maxImages = "unlimited"
+			} else {
+				maxImages = strconv.Itoa(s.Info.MaxImages)
+			}
+			if s.Info.TotalMemory > 0 {
+				memory = formatBytes(s.Info.AvailableMemory) + "/" + formatBytes(s.Info.TotalMemory)
+			}
+			if s.Info.CPUCores > 0 {
+				cpu = strconv.Itoa(s.Info.CPUCores)
+			}
+		}
+
+		authRequired := "?"
+		if auth[i].err == nil {
+			if auth[i].required {
+				authRequired = "required"
+			} else {
+				authRequired = "none"
+			}
+		}
+
+		token := "-"
+		if s.Node.Token != "" {
+			token = "?"
+			if s.Online() {
+				token = "valid"
+			} else if auth[i].err == nil && auth[i].required {
+				token = "invalid"
+			}
+		}
+
+		if !s.Online() {
+			switch {
+			case s.Err == odm.ErrAuthRequired:
+				status = "login required"
+			case token == "invalid":
+				status = "unauthorized"
+			default:
+				status = "offline"
+				logger.Debug(s.Name + ": " + s.Err.Error())
+			}
+		}
+
+		if logger.JSON() {
+			fields := logger.Fields{
+				"name":         s.Name,
+				"url":          s.Node.URL,
+				"status":       status,
+				"authRequired": authRequired,
+				"token":        token,
+			}
+			if s.Online() {
+				fields["latencyMs"] = s.Latency.Milliseconds()
+				fields["info"] = s.Info
+				fields["clusterodm"] = s.Info.IsClusterODM() || s.Node.HasTag(odm.ClusterTag)
+			}
+			logger.Event("node_status", "", fields)
+		}
+
+		fmt.Fprintln(w, s.Name+"\t"+status+"\t"+latency+"\t"+version+"\t"+engine+"\t"+queue+"\t"+maxImages+"\t"+memory+"\t"+cpu+"\t"+authRequired+"\t"+token)
+	}
+	w.Flush()
+
+	// The NodeODM API doesn't expose the nodes behind a ClusterODM instance
+	if clusters && !logger.JSON() {
+		fmt.Fprintln(&buf, "\nClusterODM instances show the combined queue and capacity of their nodes. Use the ClusterODM admin interface to see each node.")
+	}
+
+	return buf.String()
+}
+
+func formatBytes(b int64) string {
+	return fmt.Sprintf("%.1fG", float64(b)/(1024*1024*1024))
+}
+
+func init() {
+	statusCmd.Flags().BoolVarP(&watchStatus, "watch", "w", false, "refresh the status periodically")
+	statusCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "refresh interval when using --watch")
+
+	nodeCmd.AddCommand(statusCmd)
+}
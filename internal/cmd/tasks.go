@@ -0,0 +1,90 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/spf13/cobra"
+)
+
+var tasksLimit int
+
+var tasksCmd = &cobra.Command{
+	Use:   "tasks",
+	Short: "List the tasks processed from this computer",
+	Run: func(cmd *cobra.Command, args []string) {
+		j, err := journal.Default()
+		if err != nil {
+			logger.Error(err)
+		}
+
+		entries, err := j.Entries()
+		if err != nil {
+			logger.Error(err)
+		}
+		if tasksLimit > 0 && len(entries) > tasksLimit {
+			entries = entries[len(entries)-tasksLimit:]
+		}
+
+		if logger.JSON() {
+			for _, e := range entries {
+				logger.Event("task", "", logger.Fields{
+					"id":      e.ID,
+					"name":    e.Name,
+					"input":   e.Input,
+					"output":  e.Output,
+					"node":    e.Node,
+					"uuid":    e.UUID,
+					"status":  e.Status,
+					"error":   e.Error,
+					"started": e.Started,
+					"updated": e.Updated,
+				})
+			}
+			return
+		}
+
+		if len(entries) == 0 {
+			logger.Info("No tasks yet")
+			return
+		}
+
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STARTED\tNAME\tINPUT\tNODE\tUUID\tSTATUS\tOUTPUT")
+		for _, e := range entries {
+			output := e.Output
+			if e.Error != "" {
+				output = e.Error
+			}
+			fmt.Fprintln(w, e.Started.Local().Format("2006-01-02 15:04")+"\t"+dash(e.Name)+"\t"+e.Input+"\t"+dash(e.Node)+"\t"+dash(e.UUID)+"\t"+e.Status+"\t"+output)
+		}
+		w.Flush()
+
+		logger.Info(buf.String())
+	},
+}
+
+func init() {
+	tasksCmd.Flags().IntVarP(&tasksLimit, "limit", "l", 20, "number of recent tasks to show (0 shows all)")
+
+	rootCmd.AddCommand(tasksCmd)
+}
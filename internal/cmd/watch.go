@@ -0,0 +1,224 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/config"
+	"github.com/OpenDroneMap/CloudODM/internal/fs"
+	"github.com/OpenDroneMap/CloudODM/internal/journal"
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/watch"
+
+	"github.com/spf13/cobra"
+)
+
+var presetName string
+var stableTime time.Duration
+var pollInterval time.Duration
+var doneDir string
+var failedDir string
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [flags] <dir> [args]",
+	Short: "Process new folders copied to a directory",
+	Long: `Process new folders copied to a directory.
+
+Each subfolder of <dir> is processed once it's ready: when it contains a
+.ready file, or when its contents have not changed for --stable-time.
+Images are searched in the whole subfolder (e.g. DCIM/100MEDIA). Results
+are saved to a subfolder of --output with the same name, then the input
+folder is moved to --done-dir or --failed-dir.
+
+Task options can be taken from a preset (see odm preset) and/or passed
+after <dir>.`,
+	Example: "  odm watch --preset fast incoming/\n  odm watch -n auto incoming/ --dsm",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		user := config.Initialize()
+
+		dir := args[0]
+		if !fs.IsDirectory(dir) {
+			logger.Error(dir + " is not a directory")
+		}
+
+		options := []string{}
+		node := nodeName
+		if presetName != "" {
+			preset, err := user.GetPreset(presetName)
+			if err != nil {
+				logger.Error(err)
+			}
+			options = append(options, preset.Options...)
+			if preset.Node != "" && !cmd.Flags().Changed("node") {
+				node = preset.Node
+			}
+		}
+		options = append(options, args[1:]...)
+
+		if force && !cmd.Flags().Changed("output-mode") {
+			outputMode = outputModeOverwrite
+		}
+		if doneDir == "" {
+			doneDir = filepath.Join(dir, "done")
+		}
+		if failedDir == "" {
+			failedDir = filepath.Join(dir, "failed")
+		}
+		for _, d := range []string{doneDir, failedDir} {
+			if err := os.MkdirAll(d, 0755); err != nil {
+				logger.Error(err)
+			}
+		}
+
+		// Progress bars of different folders would overlap
+		logger.DisableProgress()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		scanner := watch.NewScanner(dir, stableTime, skippedFolders(dir, outputPath, doneDir, failedDir)...)
+		j := openJournal()
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		slots := make(chan bool, concurrency)
+		var wg sync.WaitGroup
+
+		logger.Info("Watching " + dir + " for new folders (press CTRL+C to exit)")
+
+		for ctx.Err() == nil {
+			ready, err := scanner.Scan(time.Now())
+			if err != nil {
+				logger.Warn(err)
+			}
+
+			for _, name := range ready {
+				d := watchDataset(dir, name, node, options)
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					select {
+					case slots <- true:
+					case <-ctx.Done():
+						return
+					}
+					defer func() { <-slots }()
+
+					entry := runBatchDataset(ctx, user, j, d)
+					if entry.Status != journal.StatusCanceled {
+						moveInput(d, entry)
+					}
+				}()
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(pollInterval):
+			}
+		}
+
+		logger.Info("Stopping...")
+		wg.Wait()
+		printMetricsSummary()
+	},
+}
+
+// watchDataset creates a dataset from a folder of a watched directory
+func watchDataset(dir string, name string, node string, options []string) dataset {
+	input := filepath.Join(dir, name)
+	files := []string{}
+	filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return dataset{
+		Name:       name,
+		Input:      absPath(input),
+		Files:      filterImagesAndText(files),
+		Options:    options,
+		Node:       node,
+		Output:     filepath.Join(outputPath, name),
+		OutputMode: outputMode,
+		OnComplete: onComplete,
+		OnFailure:  onFailure,
+	}
+}
+
+// moveInput moves a processed folder to the done or failed directory
+func moveInput(d dataset, entry journal.Entry) {
+	dest := doneDir
+	if entry.Status != journal.StatusCompleted {
+		dest = failedDir
+	}
+
+	target := filepath.Join(dest, d.Name)
+	if _, err := os.Stat(target); err == nil {
+		target += "_" + time.Now().Format("2006-01-02T15-04-05")
+	}
+
+	if err := os.Rename(d.Input, target); err != nil {
+		logger.With(logger.Fields{"dataset": d.Name}).Warn("Cannot move input folder: " + err.Error())
+	} else {
+		logger.Info(d.Name + ": moved to " + target)
+	}
+}
+
+// skippedFolders returns the names of the folders of dir that must not
+// be processed because they are used for outputs or processed inputs
+func skippedFolders(dir string, paths ...string) []string {
+	absDir := absPath(dir)
+	skip := []string{}
+	for _, p := range paths {
+		rel, err := filepath.Rel(absDir, absPath(p))
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			skip = append(skip, strings.Split(rel, string(filepath.Separator))[0])
+		}
+	}
+	return skip
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&presetName, "preset", "", "preset with the task options (and node) to use")
+	watchCmd.Flags().DurationVar(&stableTime, "stable-time", time.Minute, "process a folder once its contents have not changed for this long")
+	watchCmd.Flags().DurationVar(&pollInterval, "interval", 10*time.Second, "how often to look for new folders")
+	watchCmd.Flags().StringVar(&doneDir, "done-dir", "", "where to move processed folders (default <dir>/done)")
+	watchCmd.Flags().StringVar(&failedDir, "failed-dir", "", "where to move folders that failed to process (default <dir>/failed)")
+	watchCmd.Flags().IntVarP(&concurrency, "concurrency", "c", 1, "number of folders to process at the same time")
+	watchCmd.Flags().StringVarP(&outputPath, "output", "o", "./output", "directory where to store the results")
+	watchCmd.Flags().StringVarP(&nodeName, "node", "n", "default", "processing node to use (\"auto\" picks the least busy node, \"@tag\" the least busy node with a tag)")
+	watchCmd.Flags().BoolVarP(&force, "force", "f", false, "replace the contents of output directories that already exist (same as --output-mode overwrite)")
+	watchCmd.Flags().StringVar(&outputMode, "output-mode", outputModeNew, "how to handle existing output directories: new, overwrite, clean or timestamped (see odm --help)")
+	watchCmd.Flags().StringVarP(&parallelConnections, "parallel-connections", "p", "5", "parallel upload connections per folder. Set to 1 to disable parallel uploads, or to \"auto\" to adjust them to the measured throughput")
+	watchCmd.Flags().IntVarP(&maxUploadRetries, "max-upload-retries", "m", 10, "max retries before giving up on a file upload when using parallel upload connections")
+	addHookFlags(watchCmd)
+	watchCmd.Flags().SetInterspersed(false)
+
+	rootCmd.AddCommand(watchCmd)
+}
@@ -16,42 +16,161 @@
 package config
 
 import (
+	"errors"
+	"net/url"
+	"os"
+
+	odmio "github.com/OpenDroneMap/CloudODM/internal/io"
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
 	"github.com/OpenDroneMap/CloudODM/internal/odm"
 )
 
+// Environment variables to run non-interactively
+const (
+	NodeURLEnv  = "ODM_NODE_URL"
+	TokenEnv    = "ODM_TOKEN"
+	UsernameEnv = "ODM_USERNAME"
+	PasswordEnv = "ODM_PASSWORD"
+)
+
 // CheckLogin checks if the node needs login
 // if it does, it attempts to login
 // it it doesn't, returns node.Info()
 // on error, it prints a message and exits
+//
+// Credentials are looked up in this order:
+//  1. username and password passed as arguments
+//  2. the ODM_TOKEN environment variable
+//  3. the token stored for the node
+//  4. the ODM_USERNAME and ODM_PASSWORD environment variables
+//  5. the netrc entry for the node host
+//  6. an interactive prompt (only if stdin is a terminal)
 func (c Configuration) CheckLogin(nodeName string, username string, password string) *odm.InfoResponse {
-	node, err := c.GetNode(nodeName)
+	info, err := c.Authenticate(nodeName, username, password)
 	if err != nil {
 		logger.Error(err)
 	}
 
+	return info
+}
+
+// Authenticate is like CheckLogin, but returns errors instead of exiting
+func (c Configuration) Authenticate(nodeName string, username string, password string) (*odm.InfoResponse, error) {
+	node, err := c.GetNode(nodeName)
+	if err != nil {
+		return nil, err
+	}
+
+	if username != "" || password != "" {
+		// Log in with these even if there's a token
+		node.Token = ""
+	}
+
 	info, err := node.Info()
+	if err == odm.ErrUnauthorized && node.Token != "" {
+		// The token was rejected (expired?), try the other credentials
+		logger.Debug("The node rejected the token, logging in again")
+		node.Token = ""
+	}
 	err = node.CheckAuthentication(err)
+	if err == odm.ErrAuthRequired {
+		return c.login(nodeName, node, username, password)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Login logs in with a node using username and password
+// and stores the new token
+func (c Configuration) Login(nodeName string, username string, password string) (*odm.InfoResponse, error) {
+	node, err := c.GetNode(nodeName)
 	if err != nil {
-		if err == odm.ErrAuthRequired {
-			token, err := node.TryLogin(username, password)
-			if err != nil {
-				logger.Error(err)
-			}
-
-			// Validate token
-			node.Token = token
-			info, err = node.Info()
-			err = node.CheckAuthentication(err)
-			if err != nil {
-				logger.Error(err)
-			}
-
-			c.UpdateNode(nodeName, *node)
-		} else {
-			logger.Error(err)
+		return nil, err
+	}
+
+	return c.login(nodeName, node, username, password)
+}
+
+// login obtains a new token for the node, validates it and stores it
+func (c Configuration) login(nodeName string, node *odm.Node, username string, password string) (*odm.InfoResponse, error) {
+	var err error
+	if username == "" && password == "" {
+		username, password, err = c.Credentials(*node)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	return info
+	token, err := node.TryLogin(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate token
+	node.Token = token
+	info, err := node.Info()
+	err = node.CheckAuthentication(err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the token out of URLs if the node allows it
+	if supported, err := node.SupportsAuthHeader(); err == nil && supported != node.AuthHeader {
+		node.AuthHeader = supported
+		if supported {
+			logger.Debug("The node accepts tokens in the Authorization header, using it")
+		}
+	}
+
+	if err := c.UpdateNode(nodeName, *node); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// Reauthenticator returns a function that logs in again with a node
+// (see CheckLogin for where credentials are taken from) and returns
+// the new token. It is meant to be called when a token expires.
+func (c Configuration) Reauthenticator(nodeName string) func() (string, error) {
+	return func() (string, error) {
+		node, err := c.getStoredNode(nodeName)
+		if err != nil {
+			return "", err
+		}
+		node.Token = ""
+
+		if _, err := c.login(nodeName, node, "", ""); err != nil {
+			return "", err
+		}
+
+		return node.Token, nil
+	}
+}
+
+// Credentials returns the username and password to login with a node from
+// the environment or the netrc file. If none are found, empty credentials
+// are returned so that the user is prompted for them, unless stdin
+// is not a terminal.
+func (c Configuration) Credentials(node odm.Node) (string, string, error) {
+	if username := os.Getenv(UsernameEnv); username != "" {
+		logger.Trace("Using credentials from " + UsernameEnv + " and " + PasswordEnv)
+		return username, os.Getenv(PasswordEnv), nil
+	}
+
+	if u, err := url.Parse(node.URL); err == nil {
+		if username, password, ok := netrcCredentials(u.Host); ok {
+			logger.Trace("Using credentials from netrc for " + u.Host)
+			return username, password, nil
+		}
+	}
+
+	if !odmio.IsInteractive() {
+		return "", "", errors.New("Authentication required. Set " + TokenEnv + " or " + UsernameEnv + "/" + PasswordEnv +
+			", add the node to your netrc file or run ./odm login")
+	}
+
+	return "", "", nil
 }
@@ -0,0 +1,79 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	logins := 0
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/info":
+			if r.URL.Query().Get("token") != "new" {
+				w.Write([]byte(`{"error":"Invalid authentication token"}`))
+				return
+			}
+			w.Write([]byte(`{"version":"2.2.0","maxImages":100}`))
+		case "/auth/info":
+			w.Write([]byte(`{"loginUrl":"` + ts.URL + `/login"}`))
+		case "/login":
+			logins++
+			w.Write([]byte(`{"token":"new"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "odm-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(UsernameEnv)
+	defer os.Unsetenv(PasswordEnv)
+
+	c := NewConfiguration(filepath.Join(dir, "odm.json"))
+	if err := c.AddNode("default", ts.URL+"/?token=expired"); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(UsernameEnv, "user")
+	os.Setenv(PasswordEnv, "pass")
+	if _, err := c.Authenticate("default", "", ""); err != nil {
+		t.Fatal("Should have logged in with the environment credentials:", err)
+	}
+	if token := c.Nodes["default"].Token; token != "new" {
+		t.Error("The new token should have been stored, have", token)
+	}
+
+	// Credentials passed as arguments are used even with a valid token
+	if _, err := c.Authenticate("default", "other", "pass"); err != nil {
+		t.Fatal(err)
+	}
+	if logins != 2 {
+		t.Error("Should have logged in again with the credentials passed as arguments")
+	}
+}
@@ -20,14 +20,17 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/OpenDroneMap/CloudODM/internal/fs"
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/notify"
 	"github.com/OpenDroneMap/CloudODM/internal/odm"
 
 	homedir "github.com/mitchellh/go-homedir"
@@ -37,20 +40,43 @@ import (
 func NewConfiguration(filePath string) Configuration {
 	conf := Configuration{}
 	conf.Nodes = map[string]odm.Node{}
+	conf.Presets = map[string]Preset{}
+	conf.Notifiers = map[string]notify.Config{}
 	conf.filePath = filePath
+	conf.mu = &sync.RWMutex{}
 	return conf
 }
 
 // Save saves the configuration to file
 func (c Configuration) Save() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.save()
+}
+
+// save is like Save, for callers that already hold the write lock
+func (c Configuration) save() {
+	if c.readOnly {
+		logger.Trace("Not saving configuration (" + NodeURLEnv + " is set)")
+		return
+	}
 	saveToFile(c, c.filePath)
 }
 
 // Configuration is a collection of config values
 type Configuration struct {
-	Nodes map[string]odm.Node `json:"nodes"`
+	Nodes           map[string]odm.Node      `json:"nodes"`
+	CredentialStore string                   `json:"credentialStore,omitempty"`
+	Presets         map[string]Preset        `json:"presets,omitempty"`
+	Notifiers       map[string]notify.Config `json:"notifiers,omitempty"`
 
 	filePath string
+	readOnly bool
+
+	// mu guards the maps above and the configuration and credential
+	// files, which are shared by the tasks run by batch, watch and serve.
+	// It's a pointer so that copies of the configuration share it.
+	mu *sync.RWMutex
 }
 
 // Initialize the configuration
@@ -68,6 +94,8 @@ func Initialize() Configuration {
 	if exists, _ := fs.FileExists(cfgPath); exists {
 		// Read existing config
 		user = loadFromFile(cfgPath)
+	} else if os.Getenv(NodeURLEnv) != "" {
+		user = NewConfiguration(cfgPath)
 	} else {
 		// Download public nodes, choose a default
 		nodes := GetPublicNodes()
@@ -76,15 +104,30 @@ func Initialize() Configuration {
 		logger.Info("Found " + strconv.Itoa(len(nodes)) + " public nodes")
 
 		if len(nodes) > 0 {
-			logger.Info("Picking a random one...")
+			logger.Info("Checking which one is the most available...")
 
-			randomNode := nodes[rand.Intn(len(nodes))]
+			if publicNode, err := ChoosePublicNode(nodes); err == nil {
+				logger.Info("Setting default node to " + publicNode.String())
+				user.AddNode("default", publicNode.Url)
 
-			logger.Info("Setting default node to " + randomNode.String())
-			user.AddNode("default", randomNode.Url)
+				logger.Info("Initialized configuration at " + cfgPath)
+			} else {
+				logger.Warn(err.Error() + ". Add a node with ./odm node add or try again later")
+			}
+		}
+	}
 
-			logger.Info("Initialized configuration at " + cfgPath)
+	if nodeURL := os.Getenv(NodeURLEnv); nodeURL != "" {
+		u, err := url.ParseRequestURI(nodeURL)
+		if err != nil {
+			logger.Error(NodeURLEnv + ": " + nodeURL + " is not a valid URL")
 		}
+
+		// The default node is taken from the environment,
+		// don't write it to the configuration file
+		user.readOnly = true
+		user.Nodes["default"] = odm.Node{URL: u.Scheme + "://" + u.Host, Token: u.Query().Get("token")}
+		logger.Trace("Using default node from " + NodeURLEnv + ": " + u.Scheme + "://" + u.Host)
 	}
 
 	return user
@@ -96,15 +139,11 @@ func saveToFile(conf Configuration, filePath string) {
 		logger.Error(err)
 	}
 
-	jsonFile, err := os.Create(filePath)
-	if err != nil {
+	if err := writePrivateFile(filePath, jsonData); err != nil {
 		logger.Error(err)
 	}
-	defer jsonFile.Close()
 
-	jsonFile.Write(jsonData)
-
-	logger.Debug("Wrote configuration to " + filePath)
+	logger.Trace("Wrote configuration to " + filePath)
 }
 
 func loadFromFile(filePath string) Configuration {
@@ -112,7 +151,7 @@ func loadFromFile(filePath string) Configuration {
 	if err != nil {
 		logger.Error(err)
 	}
-	logger.Debug("Loaded configuration from " + filePath)
+	logger.Trace("Loaded configuration from " + filePath)
 
 	defer jsonFile.Close()
 
@@ -132,7 +171,14 @@ func loadFromFile(filePath string) Configuration {
 
 // AddNode adds a new node to the configuration
 func (c Configuration) AddNode(name string, nodeURL string) error {
-	if _, ok := c.Nodes[name]; ok {
+	if name == AutoNodeName || strings.HasPrefix(name, GroupPrefix) {
+		return errors.New(name + " is a reserved node name")
+	}
+
+	c.mu.RLock()
+	_, ok := c.Nodes[name]
+	c.mu.RUnlock()
+	if ok {
 		return errors.New("node" + name + " already exists. Remove it first.")
 	}
 
@@ -141,24 +187,95 @@ func (c Configuration) AddNode(name string, nodeURL string) error {
 		return errors.New(nodeURL + " is not a valid URL. A valid URL looks like: http://hostname:port/?token=optional")
 	}
 
-	c.Nodes[name] = odm.Node{URL: u.Scheme + "://" + u.Host, Token: u.Query().Get("token")}
-	c.Save()
+	return c.UpdateNode(name, odm.Node{URL: u.Scheme + "://" + u.Host, Token: u.Query().Get("token")})
+}
+
+// ResetDefaultNode chooses the healthiest public node again and
+// sets it as the default node
+func (c Configuration) ResetDefaultNode() error {
+	nodes := GetPublicNodes()
+	if len(nodes) == 0 {
+		return errors.New("No public nodes found")
+	}
+
+	publicNode, err := ChoosePublicNode(nodes)
+	if err != nil {
+		return errors.New(err.Error() + ", keeping the current default node")
+	}
+	if err := c.ReplaceNode("default", odm.Node{URL: publicNode.Url}); err != nil {
+		return err
+	}
 
+	logger.Info("Default node set to " + publicNode.String())
 	return nil
 }
 
 // RemoveNode removes a node from the configuration
 func (c Configuration) RemoveNode(name string) bool {
-	_, ok := c.Nodes[name]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node, ok := c.Nodes[name]
 	if ok {
+		c.forgetToken(name, node.URL)
 		delete(c.Nodes, name)
-		c.Save()
+		c.save()
 	}
 	return ok
 }
 
-// GetNode gets a Node instance given its name
+// ReplaceNode replaces a node with a different one (e.g. another
+// public node), removing the token of the previous node
+func (c Configuration) ReplaceNode(name string, node odm.Node) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.Nodes[name]; ok && old.URL != node.URL {
+		c.forgetToken(name, old.URL)
+	}
+	return c.updateNode(name, node)
+}
+
+// forgetToken removes the token of nodeURL from the credential store,
+// unless a node other than name still uses it. The caller must hold
+// the write lock.
+func (c Configuration) forgetToken(name string, nodeURL string) {
+	for n, node := range c.Nodes {
+		if n != name && node.URL == nodeURL {
+			return
+		}
+	}
+
+	store, err := c.credentialStore()
+	if err != nil || store.Name() == PlaintextStore {
+		return
+	}
+	if err := store.Delete(nodeURL); err != nil {
+		logger.Warn("Cannot remove token from the " + store.Name() + " credential store: " + err.Error())
+	}
+}
+
+// GetNode gets a Node instance given its name. If ODM_TOKEN
+// is set, it replaces the node's token.
 func (c Configuration) GetNode(name string) (*odm.Node, error) {
+	node, err := c.getStoredNode(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := os.Getenv(TokenEnv); token != "" {
+		node.Token = token
+	}
+
+	return node, nil
+}
+
+// getStoredNode gets a Node instance given its name, reading its token
+// from the credential store
+func (c Configuration) getStoredNode(name string) (*odm.Node, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	if len(c.Nodes) == 0 {
 		return nil, errors.New("No nodes. Add one with ./odm node")
 	}
@@ -168,10 +285,428 @@ func (c Configuration) GetNode(name string) (*odm.Node, error) {
 		return nil, errors.New("node: " + name + " does not exist")
 	}
 
+	if node.Token == "" && c.CredentialStore != "" && c.CredentialStore != PlaintextStore {
+		store, err := c.credentialStore()
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := store.Get(node.URL)
+		if err == nil {
+			node.Token = token
+		} else if err != ErrCredentialNotFound {
+			logger.Warn("Cannot read token from the " + store.Name() + " credential store: " + err.Error())
+		}
+	}
+
 	return &node, nil
 }
 
-func (c Configuration) UpdateNode(name string, node odm.Node) {
+// NodeNames returns the names of all nodes, sorted alphabetically
+func (c Configuration) NodeNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for name := range c.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetNodeTransport changes the HTTP settings used to connect to a node
+func (c Configuration) SetNodeTransport(name string, transport odm.Transport) error {
+	node, err := c.getStoredNode(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := transport.NewClient(); err != nil {
+		return err
+	}
+
+	if transport == (odm.Transport{}) {
+		node.Transport = nil
+	} else {
+		node.Transport = &transport
+	}
+
+	return c.UpdateNode(name, *node)
+}
+
+// SetNodeAuthHeader changes whether the token is sent to a node in an
+// Authorization header (true) or as a query parameter (false)
+func (c Configuration) SetNodeAuthHeader(name string, authHeader bool) error {
+	node, err := c.getStoredNode(name)
+	if err != nil {
+		return err
+	}
+
+	node.AuthHeader = authHeader
+	return c.UpdateNode(name, *node)
+}
+
+// NodesWithTag returns the names of the nodes tagged with tag, sorted alphabetically
+func (c Configuration) NodesWithTag(tag string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for name, node := range c.Nodes {
+		if node.HasTag(tag) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TagNode adds tags to a node
+func (c Configuration) TagNode(name string, tags ...string) error {
+	node, err := c.getStoredNode(name)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, "@, ") {
+			return errors.New("Invalid tag: \"" + tag + "\"")
+		}
+		if !node.HasTag(tag) {
+			node.Tags = append(node.Tags, tag)
+		}
+	}
+	sort.Strings(node.Tags)
+
+	return c.UpdateNode(name, *node)
+}
+
+// UntagNode removes tags from a node
+func (c Configuration) UntagNode(name string, tags ...string) error {
+	node, err := c.getStoredNode(name)
+	if err != nil {
+		return err
+	}
+
+	remaining := []string{}
+	for _, t := range node.Tags {
+		keep := true
+		for _, tag := range tags {
+			if t == tag {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			remaining = append(remaining, t)
+		}
+	}
+	node.Tags = remaining
+
+	return c.UpdateNode(name, *node)
+}
+
+// UpdateNode saves a node, storing its token in the credential store
+func (c Configuration) UpdateNode(name string, node odm.Node) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updateNode(name, node)
+}
+
+// updateNode is like UpdateNode, for callers that hold the write lock
+func (c Configuration) updateNode(name string, node odm.Node) error {
+	store, err := c.credentialStore()
+	if err != nil {
+		return err
+	}
+
+	if store.Name() != PlaintextStore {
+		if node.Token != "" {
+			err = store.Set(node.URL, node.Token)
+		} else {
+			err = store.Delete(node.URL)
+		}
+		if err != nil {
+			return errors.New("Cannot update the " + store.Name() + " credential store: " + err.Error())
+		}
+		node.Token = ""
+	}
+
 	c.Nodes[name] = node
-	c.Save()
+	c.save()
+	return nil
+}
+
+// SetCredentialStore switches to a different credential store,
+// migrating all tokens and notifier passwords to it
+func (c *Configuration) SetCredentialStore(storeName string) error {
+	if c.CredentialStore == storeName || (c.CredentialStore == "" && storeName == PlaintextStore) {
+		return nil
+	}
+
+	// Read all tokens from the current store
+	nodes := map[string]odm.Node{}
+	for _, name := range c.NodeNames() {
+		node, err := c.getStoredNode(name)
+		if err != nil {
+			return err
+		}
+		nodes[name] = *node
+	}
+	notifiers := c.GetNotifiers()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	oldStore, _ := c.credentialStore()
+
+	c.CredentialStore = storeName
+	newStore, err := c.credentialStore()
+	if err != nil {
+		c.CredentialStore = oldStore.Name()
+		return err
+	}
+
+	for name, node := range nodes {
+		if node.Token == "" {
+			continue
+		}
+		if newStore.Name() != PlaintextStore {
+			if err := newStore.Set(node.URL, node.Token); err != nil {
+				c.CredentialStore = oldStore.Name()
+				return err
+			}
+		}
+		if oldStore.Name() != PlaintextStore {
+			oldStore.Delete(node.URL)
+		}
+		c.Nodes[name] = node
+	}
+
+	for name, notifier := range notifiers {
+		if notifier.Password == "" {
+			continue
+		}
+		if newStore.Name() != PlaintextStore {
+			if err := newStore.Set(notifierCredential(name), notifier.Password); err != nil {
+				c.CredentialStore = oldStore.Name()
+				return err
+			}
+			notifier.Password = ""
+		}
+		if oldStore.Name() != PlaintextStore {
+			oldStore.Delete(notifierCredential(name))
+		}
+		c.Notifiers[name] = notifier
+	}
+
+	for name, node := range c.Nodes {
+		if newStore.Name() != PlaintextStore {
+			node.Token = ""
+		}
+		c.Nodes[name] = node
+	}
+	c.save()
+
+	logger.Info("Tokens are now stored in the " + newStore.Name() + " credential store")
+	return nil
+}
+
+// MigrateTokens moves tokens (and notifier passwords) left in the
+// configuration file to the configured credential store
+func (c Configuration) MigrateTokens() {
+	if c.CredentialStore == "" || c.CredentialStore == PlaintextStore {
+		return
+	}
+
+	for _, name := range c.NodeNames() {
+		c.mu.RLock()
+		node := c.Nodes[name]
+		c.mu.RUnlock()
+		if node.Token != "" {
+			logger.Debug("Moving token of " + name + " to the " + c.CredentialStore + " credential store")
+			if err := c.UpdateNode(name, node); err != nil {
+				logger.Warn(err)
+			}
+		}
+	}
+
+	for _, name := range c.NotifierNames() {
+		c.mu.RLock()
+		notifier := c.Notifiers[name]
+		c.mu.RUnlock()
+		if notifier.Password != "" {
+			logger.Debug("Moving SMTP password of " + name + " to the " + c.CredentialStore + " credential store")
+			if err := c.AddNotifier(name, notifier); err != nil {
+				logger.Warn(err)
+			}
+		}
+	}
+}
+
+// Preset is a named set of task options, optionally with a node
+type Preset struct {
+	Node    string   `json:"node,omitempty"`
+	Options []string `json:"options"`
+}
+
+// AddPreset saves a preset, replacing any preset with the same name
+func (c Configuration) AddPreset(name string, preset Preset) error {
+	if name == "" || strings.ContainsAny(name, "@, ") {
+		return errors.New("Invalid preset name: \"" + name + "\"")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Presets[name] = preset
+	c.save()
+	return nil
+}
+
+// RemovePreset removes a preset from the configuration
+func (c Configuration) RemovePreset(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.Presets[name]
+	if ok {
+		delete(c.Presets, name)
+		c.save()
+	}
+	return ok
+}
+
+// GetPreset gets a preset given its name
+func (c Configuration) GetPreset(name string) (*Preset, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	preset, ok := c.Presets[name]
+	if !ok {
+		return nil, errors.New("preset: " + name + " does not exist. Add it with ./odm preset add")
+	}
+	return &preset, nil
+}
+
+// PresetNames returns the names of all presets, sorted alphabetically
+func (c Configuration) PresetNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for name := range c.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddNotifier saves a notifier, replacing any notifier with the same name
+func (c Configuration) AddNotifier(name string, notifier notify.Config) error {
+	if name == "" || strings.ContainsAny(name, ", ") {
+		return errors.New("Invalid notifier name: \"" + name + "\"")
+	}
+	if err := notifier.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The SMTP password is kept in the credential store, like node tokens
+	store, err := c.credentialStore()
+	if err != nil {
+		return err
+	}
+	if store.Name() != PlaintextStore {
+		if notifier.Password != "" {
+			err = store.Set(notifierCredential(name), notifier.Password)
+		} else {
+			err = store.Delete(notifierCredential(name))
+		}
+		if err != nil {
+			return errors.New("Cannot update the " + store.Name() + " credential store: " + err.Error())
+		}
+		notifier.Password = ""
+	}
+
+	c.Notifiers[name] = notifier
+	c.save()
+	return nil
+}
+
+// RemoveNotifier removes a notifier from the configuration
+func (c Configuration) RemoveNotifier(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.Notifiers[name]
+	if ok {
+		if store, err := c.credentialStore(); err == nil && store.Name() != PlaintextStore {
+			store.Delete(notifierCredential(name))
+		}
+		delete(c.Notifiers, name)
+		c.save()
+	}
+	return ok
+}
+
+// GetNotifier gets a notifier given its name, reading its SMTP
+// password from the credential store
+func (c Configuration) GetNotifier(name string) (*notify.Config, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	notifier, ok := c.Notifiers[name]
+	if !ok {
+		return nil, errors.New("Notifier " + name + " does not exist")
+	}
+
+	if notifier.Type == notify.TypeEmail && notifier.Password == "" && c.CredentialStore != "" && c.CredentialStore != PlaintextStore {
+		store, err := c.credentialStore()
+		if err != nil {
+			return nil, err
+		}
+
+		password, err := store.Get(notifierCredential(name))
+		if err == nil {
+			notifier.Password = password
+		} else if err != ErrCredentialNotFound {
+			logger.Warn("Cannot read SMTP password from the " + store.Name() + " credential store: " + err.Error())
+		}
+	}
+
+	return &notifier, nil
+}
+
+// GetNotifiers returns all notifiers by name (see GetNotifier)
+func (c Configuration) GetNotifiers() map[string]notify.Config {
+	notifiers := map[string]notify.Config{}
+	for _, name := range c.NotifierNames() {
+		if notifier, err := c.GetNotifier(name); err == nil {
+			notifiers[name] = *notifier
+		}
+	}
+	return notifiers
+}
+
+// notifierCredential is the key of the SMTP password
+// of a notifier in the credential store
+func notifierCredential(name string) string {
+	return "notify:" + name
+}
+
+// NotifierNames returns the names of all notifiers, sorted alphabetically
+func (c Configuration) NotifierNames() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	for name := range c.Notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -16,8 +16,13 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
+
+	"github.com/OpenDroneMap/CloudODM/internal/notify"
 )
 
 func TestNodes(t *testing.T) {
@@ -70,3 +75,174 @@ func TestNodes(t *testing.T) {
 		t.Error("Can get nonexistant node")
 	}
 }
+
+func TestTags(t *testing.T) {
+	f, err := ioutil.TempFile("", "odm-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	c := NewConfiguration(f.Name())
+
+	c.AddNode("big-1", "http://localhost:3000")
+	c.AddNode("big-2", "http://localhost:3001")
+	c.AddNode("small", "http://localhost:3002")
+
+	if c.AddNode("auto", "http://localhost") == nil || c.AddNode("@gpu", "http://localhost") == nil {
+		t.Error("Should not be able to add nodes with reserved names")
+	}
+
+	if err := c.TagNode("big-1", "gpu", "cpu-large"); err != nil {
+		t.Error(err)
+	}
+	c.TagNode("big-2", "gpu")
+	c.TagNode("big-2", "gpu")
+
+	if c.TagNode("nonexistant", "gpu") == nil {
+		t.Error("Should not be able to tag a nonexistant node")
+	}
+	if c.TagNode("small", "@invalid") == nil {
+		t.Error("Should not be able to add invalid tags")
+	}
+	if len(c.Nodes["big-2"].Tags) != 1 {
+		t.Error("Tags should not be duplicated")
+	}
+
+	members, err := c.GroupMembers("@gpu")
+	if err != nil || len(members) != 2 || members[0] != "big-1" || members[1] != "big-2" {
+		t.Error("@gpu should have big-1 and big-2 as members:", members)
+	}
+
+	c.UntagNode("big-1", "gpu")
+	members, _ = c.GroupMembers("@gpu")
+	if len(members) != 1 || members[0] != "big-2" {
+		t.Error("@gpu should only have big-2 as member:", members)
+	}
+
+	os.Setenv(TokenEnv, "ci-token")
+	c.TagNode("small", "cpu")
+	c.UntagNode("small", "cpu")
+	os.Unsetenv(TokenEnv)
+	if c.Nodes["small"].Token != "" {
+		t.Error("ODM_TOKEN should not be saved when tagging nodes")
+	}
+
+	if _, err := c.GroupMembers("@on-prem"); err == nil {
+		t.Error("Empty groups should return an error")
+	}
+
+	if members, _ := c.GroupMembers("auto"); len(members) != 3 {
+		t.Error("auto should include all nodes")
+	}
+
+	if !IsNodeGroup("auto") || !IsNodeGroup("@gpu") || IsNodeGroup("default") {
+		t.Error("IsNodeGroup is not working")
+	}
+}
+
+func TestPresets(t *testing.T) {
+	f, err := ioutil.TempFile("", "odm-presets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := NewConfiguration(f.Name())
+
+	if c.AddPreset("bad name", Preset{}) == nil {
+		t.Error("Should not be able to add a preset with a space in the name")
+	}
+	if err := c.AddPreset("fast", Preset{Node: "auto", Options: []string{"--fast-orthophoto"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c = loadFromFile(f.Name())
+	preset, err := c.GetPreset("fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preset.Node != "auto" || len(preset.Options) != 1 || preset.Options[0] != "--fast-orthophoto" {
+		t.Error("Preset was not saved properly")
+	}
+	if names := c.PresetNames(); len(names) != 1 || names[0] != "fast" {
+		t.Error("Expected a single preset")
+	}
+
+	if !c.RemovePreset("fast") || c.RemovePreset("fast") {
+		t.Error("Preset should have been removed once")
+	}
+	if _, err := c.GetPreset("fast"); err == nil {
+		t.Error("Preset should not exist")
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	f, err := ioutil.TempFile("", "odm-notifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := NewConfiguration(f.Name())
+
+	if c.AddNotifier("chat", notify.Config{Type: notify.TypeWebhook, URL: "not a url"}) == nil {
+		t.Error("Should not be able to add an invalid notifier")
+	}
+	if err := c.AddNotifier("chat", notify.Config{Type: notify.TypeWebhook, URL: "https://example.com/hook", Events: []string{"failed"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c = loadFromFile(f.Name())
+	if names := c.NotifierNames(); len(names) != 1 || names[0] != "chat" {
+		t.Fatal("Expected a single notifier")
+	}
+	if n := c.Notifiers["chat"]; n.URL != "https://example.com/hook" || n.Wants("completed") {
+		t.Error("Notifier was not saved properly")
+	}
+
+	if !c.RemoveNotifier("chat") || c.RemoveNotifier("chat") {
+		t.Error("Notifier should have been removed once")
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	f, err := ioutil.TempFile("", "odm-concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	c := NewConfiguration(f.Name())
+	c.AddNode("default", "http://localhost:3000")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				node, err := c.GetNode("default")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				node.Token = strconv.Itoa(i)
+				if err := c.UpdateNode("default", *node); err != nil {
+					t.Error(err)
+					return
+				}
+				c.NodeNames()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	c = loadFromFile(f.Name())
+	if _, err := c.GetNode("default"); err != nil {
+		t.Error(err)
+	}
+}
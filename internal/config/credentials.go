@@ -0,0 +1,343 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Credential store backends
+const (
+	PlaintextStore = "plaintext"
+	KeyringStore   = "keyring"
+	EncryptedStore = "encrypted"
+)
+
+// CredentialStores lists the available credential store backends
+var CredentialStores = []string{PlaintextStore, KeyringStore, EncryptedStore}
+
+// PassphraseEnv is the environment variable holding the passphrase of the
+// encrypted credential store
+const PassphraseEnv = "ODM_CREDENTIALS_PASSPHRASE"
+
+// ErrCredentialNotFound means no token is stored for a node
+var ErrCredentialNotFound = errors.New("Credential not found")
+
+// CredentialStore stores node tokens, keyed by node URL
+type CredentialStore interface {
+	Name() string
+	Get(nodeURL string) (string, error)
+	Set(nodeURL string, token string) error
+	Delete(nodeURL string) error
+}
+
+// credentialStore returns the credential store configured for c
+func (c Configuration) credentialStore() (CredentialStore, error) {
+	switch c.CredentialStore {
+	case "", PlaintextStore:
+		return plaintextStore{c.Nodes}, nil
+	case KeyringStore:
+		return keyringStore{}, nil
+	case EncryptedStore:
+		return encryptedStore{strings.TrimSuffix(c.filePath, ".json") + ".credentials"}, nil
+	default:
+		return nil, errors.New("Invalid credential store " + c.CredentialStore + " (valid stores are: " + strings.Join(CredentialStores, ", ") + ")")
+	}
+}
+
+// plaintextStore keeps tokens in the configuration file, next to the node URL
+type plaintextStore struct {
+	nodes map[string]odm.Node
+}
+
+func (s plaintextStore) Name() string {
+	return PlaintextStore
+}
+
+func (s plaintextStore) Get(nodeURL string) (string, error) {
+	for _, n := range s.nodes {
+		if n.URL == nodeURL && n.Token != "" {
+			return n.Token, nil
+		}
+	}
+	return "", ErrCredentialNotFound
+}
+
+func (s plaintextStore) Set(nodeURL string, token string) error {
+	for name, n := range s.nodes {
+		if n.URL == nodeURL {
+			n.Token = token
+			s.nodes[name] = n
+		}
+	}
+	return nil
+}
+
+func (s plaintextStore) Delete(nodeURL string) error {
+	return s.Set(nodeURL, "")
+}
+
+// keyringStore keeps tokens in the operating system keyring
+// (Secret Service via secret-tool on Linux, Keychain on macOS)
+type keyringStore struct{}
+
+const keyringService = "cloudodm"
+
+// securityItemNotFound is the exit code of the macOS security tool
+// when an item is not in the keychain (errSecItemNotFound)
+const securityItemNotFound = 44
+
+func (s keyringStore) Name() string {
+	return KeyringStore
+}
+
+func (s keyringStore) run(stdin string, name string, args ...string) (string, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return "", errors.New("The keyring credential store requires " + name + " (not supported on " + runtime.GOOS + "?)")
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return "", err
+		}
+
+		// secret-tool exits with 1 and no message when nothing matches
+		message := strings.TrimSpace(stderr.String())
+		if (name == "security" && exitErr.ExitCode() == securityItemNotFound) ||
+			(name == "secret-tool" && exitErr.ExitCode() == 1 && message == "") {
+			return "", ErrCredentialNotFound
+		}
+
+		if message == "" {
+			message = err.Error()
+		}
+		return "", errors.New(name + ": " + message)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (s keyringStore) Get(nodeURL string) (string, error) {
+	var token string
+	var err error
+	if runtime.GOOS == "darwin" {
+		token, err = s.run("", "security", "find-generic-password", "-s", keyringService, "-a", nodeURL, "-w")
+	} else {
+		token, err = s.run("", "secret-tool", "lookup", "service", keyringService, "url", nodeURL)
+	}
+	if err == nil && token == "" {
+		err = ErrCredentialNotFound
+	}
+	return token, err
+}
+
+func (s keyringStore) Set(nodeURL string, token string) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		// Pass the token on stdin (in interactive mode) rather than
+		// as an argument, which other users could see with ps
+		_, err = s.run("add-generic-password -U -s "+securityQuote(keyringService)+" -a "+securityQuote(nodeURL)+" -w "+securityQuote(token)+"\n", "security", "-i")
+		if err == nil {
+			// Errors of commands don't change the exit code in interactive mode
+			if stored, _ := s.Get(nodeURL); stored != token {
+				err = ErrCredentialNotFound
+			}
+		}
+	} else {
+		_, err = s.run(token, "secret-tool", "store", "--label=CloudODM token for "+nodeURL, "service", keyringService, "url", nodeURL)
+	}
+	if err == ErrCredentialNotFound {
+		return errors.New("Cannot store token in the keyring")
+	}
+	return err
+}
+
+// securityQuote quotes an argument for the interactive mode of security
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func (s keyringStore) Delete(nodeURL string) error {
+	var err error
+	if runtime.GOOS == "darwin" {
+		_, err = s.run("", "security", "delete-generic-password", "-s", keyringService, "-a", nodeURL)
+	} else {
+		_, err = s.run("", "secret-tool", "clear", "service", keyringService, "url", nodeURL)
+	}
+	if err == ErrCredentialNotFound {
+		return nil
+	}
+	return err
+}
+
+// encryptedStore keeps tokens in a file encrypted with AES-GCM, using a key
+// derived with scrypt from the passphrase in ODM_CREDENTIALS_PASSPHRASE
+type encryptedStore struct {
+	filePath string
+}
+
+type encryptedFile struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+func (s encryptedStore) Name() string {
+	return EncryptedStore
+}
+
+func (s encryptedStore) cipher(salt []byte) (cipher.AEAD, error) {
+	passphrase := os.Getenv(PassphraseEnv)
+	if passphrase == "" {
+		return nil, errors.New("Set " + PassphraseEnv + " to use the encrypted credential store")
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s encryptedStore) load() (map[string]string, error) {
+	tokens := map[string]string{}
+
+	data, err := ioutil.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f := encryptedFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New("Cannot parse " + s.filePath + ": " + err.Error())
+	}
+
+	aead, err := s.cipher(f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Data, nil)
+	if err != nil {
+		return nil, errors.New("Cannot decrypt " + s.filePath + " (wrong passphrase?)")
+	}
+
+	if err := json.Unmarshal(plaintext, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s encryptedStore) save(tokens map[string]string) error {
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+
+	f := encryptedFile{Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := s.cipher(f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plaintext, nil)
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(s.filePath, data)
+}
+
+func (s encryptedStore) Get(nodeURL string) (string, error) {
+	tokens, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	token, ok := tokens[nodeURL]
+	if !ok {
+		return "", ErrCredentialNotFound
+	}
+	return token, nil
+}
+
+func (s encryptedStore) Set(nodeURL string, token string) error {
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens[nodeURL] = token
+	return s.save(tokens)
+}
+
+func (s encryptedStore) Delete(nodeURL string) error {
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[nodeURL]; !ok {
+		return nil
+	}
+	delete(tokens, nodeURL)
+	return s.save(tokens)
+}
+
+// writePrivateFile writes data to a file readable only by the current user
+func writePrivateFile(filePath string, data []byte) error {
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Fix permissions of files created by older versions
+	if err := f.Chmod(0600); err != nil && runtime.GOOS != "windows" {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
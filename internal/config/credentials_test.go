@@ -0,0 +1,197 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/OpenDroneMap/CloudODM/internal/notify"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(PassphraseEnv)
+
+	store := encryptedStore{filepath.Join(dir, "credentials")}
+
+	os.Unsetenv(PassphraseEnv)
+	if store.Set("http://localhost:3000", "abc") == nil {
+		t.Error("Should not be able to store tokens without a passphrase")
+	}
+
+	os.Setenv(PassphraseEnv, "secret")
+	if _, err := store.Get("http://localhost:3000"); err != ErrCredentialNotFound {
+		t.Error("Token should not have been found")
+	}
+	if err := store.Set("http://localhost:3000", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := store.Get("http://localhost:3000"); token != "abc" {
+		t.Error("Token should be abc, is", token)
+	}
+
+	data, _ := ioutil.ReadFile(store.filePath)
+	if strings.Contains(string(data), "abc") {
+		t.Error("Token should not be stored in plaintext")
+	}
+
+	os.Setenv(PassphraseEnv, "wrong")
+	if _, err := store.Get("http://localhost:3000"); err == nil {
+		t.Error("Should not be able to decrypt with the wrong passphrase")
+	}
+
+	os.Setenv(PassphraseEnv, "secret")
+	store.Delete("http://localhost:3000")
+	if _, err := store.Get("http://localhost:3000"); err != ErrCredentialNotFound {
+		t.Error("Token should have been deleted")
+	}
+}
+
+func TestCredentialStoreMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(PassphraseEnv)
+	os.Setenv(PassphraseEnv, "secret")
+
+	cfgPath := filepath.Join(dir, "odm.json")
+	c := NewConfiguration(cfgPath)
+	c.AddNode("default", "http://localhost:3000/?token=abc")
+	c.AddNotifier("me", notify.Config{Type: notify.TypeEmail, To: []string{"me@example.com"}, Host: "smtp.example.com", Username: "me", Password: "smtp-secret"})
+
+	if err := c.SetCredentialStore("invalid"); err == nil {
+		t.Error("Should not be able to set an invalid credential store")
+	}
+	if err := c.SetCredentialStore(EncryptedStore); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(cfgPath)
+	if strings.Contains(string(data), "abc") {
+		t.Error("Token should have been removed from the configuration file")
+	}
+	if strings.Contains(string(data), "smtp-secret") {
+		t.Error("SMTP password should have been removed from the configuration file")
+	}
+	if fi, _ := os.Stat(cfgPath); fi.Mode().Perm() != 0600 {
+		t.Error("Configuration file should only be readable by the user")
+	}
+
+	c = loadFromFile(cfgPath)
+	if node, _ := c.GetNode("default"); node.Token != "abc" {
+		t.Error("Token should have been read from the encrypted store")
+	}
+	if n, _ := c.GetNotifier("me"); n.Password != "smtp-secret" {
+		t.Error("SMTP password should have been read from the encrypted store")
+	}
+
+	c.AddNotifier("other", notify.Config{Type: notify.TypeEmail, To: []string{"me@example.com"}, Host: "smtp.example.com", Username: "me", Password: "other-secret"})
+	data, _ = ioutil.ReadFile(cfgPath)
+	if strings.Contains(string(data), "other-secret") {
+		t.Error("New SMTP passwords should be saved to the encrypted store")
+	}
+
+	if err := c.SetCredentialStore(PlaintextStore); err != nil {
+		t.Fatal(err)
+	}
+	if c.Nodes["default"].Token != "abc" {
+		t.Error("Token should have been migrated back to the configuration file")
+	}
+	if c.Notifiers["me"].Password != "smtp-secret" {
+		t.Error("SMTP password should have been migrated back to the configuration file")
+	}
+}
+
+func TestReplaceNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv(PassphraseEnv)
+	os.Setenv(PassphraseEnv, "secret")
+
+	c := NewConfiguration(filepath.Join(dir, "odm.json"))
+	if err := c.SetCredentialStore(EncryptedStore); err != nil {
+		t.Fatal(err)
+	}
+	c.AddNode("default", "http://localhost:3000/?token=abc")
+	c.AddNode("other", "http://localhost:3001/?token=def")
+	c.TagNode("default", "gpu")
+
+	store, _ := c.credentialStore()
+	c.ReplaceNode("default", odm.Node{URL: "http://localhost:3002"})
+	if node, _ := c.GetNode("default"); node.Token != "" || len(node.Tags) != 0 {
+		t.Error("The replaced node should not keep the previous token and tags")
+	}
+	if _, err := store.Get("http://localhost:3000"); err != ErrCredentialNotFound {
+		t.Error("The token of the previous node should have been removed")
+	}
+
+	c.RemoveNode("other")
+	if _, err := store.Get("http://localhost:3001"); err != ErrCredentialNotFound {
+		t.Error("The token of a removed node should have been removed")
+	}
+
+	// Without a passphrase the token cannot be stored
+	os.Unsetenv(PassphraseEnv)
+	if c.UpdateNode("default", odm.Node{URL: "http://localhost:3002", Token: "ghi"}) == nil {
+		t.Error("Should not be able to update a node when the credential store fails")
+	}
+	if node := c.Nodes["default"]; node.Token != "" || node.URL != "http://localhost:3002" {
+		t.Error("The node should not have been changed")
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("Uses a fake secret-tool")
+	}
+
+	dir, err := ioutil.TempDir("", "odm-keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir)
+
+	script := filepath.Join(dir, "secret-tool")
+	store := keyringStore{}
+
+	// Nothing stored: exits with 1 without a message
+	ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 1\n"), 0755)
+	if _, err := store.Get("http://localhost:3000"); err != ErrCredentialNotFound {
+		t.Error("Expected ErrCredentialNotFound, got", err)
+	}
+
+	ioutil.WriteFile(script, []byte("#!/bin/sh\necho 'Cannot autolaunch D-Bus without X11 $DISPLAY' >&2\nexit 1\n"), 0755)
+	if _, err := store.Get("http://localhost:3000"); err == nil || err == ErrCredentialNotFound || !strings.Contains(err.Error(), "D-Bus") {
+		t.Error("Expected the error of secret-tool, got", err)
+	}
+}
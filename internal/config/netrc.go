@@ -0,0 +1,139 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// netrcEntry is a machine entry of a netrc file
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// parseNetrc parses the contents of a netrc file. The "default"
+// entry, if any, is returned with an empty machine name.
+func parseNetrc(r io.Reader) []netrcEntry {
+	entries := []netrcEntry{}
+	var current *netrcEntry
+	inMacro := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Macro definitions end with an empty line
+		if inMacro {
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			value := ""
+			if i+1 < len(fields) {
+				value = fields[i+1]
+			}
+
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: value})
+				current = &entries[len(entries)-1]
+				i++
+			case "default":
+				entries = append(entries, netrcEntry{})
+				current = &entries[len(entries)-1]
+			case "login":
+				if current != nil {
+					current.login = value
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.password = value
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+
+	return entries
+}
+
+// findNetrc returns the login and password for host (a hostname or
+// hostname:port) from entries, falling back to the default entry
+func findNetrc(entries []netrcEntry, host string) (string, string, bool) {
+	hostname := host
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+
+	for _, candidate := range []string{host, hostname, ""} {
+		for _, e := range entries {
+			if e.machine == candidate && e.login != "" {
+				return e.login, e.password, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// netrcCredentials looks up the login and password for host in the user's
+// netrc file ($NETRC, ~/.netrc or ~/_netrc on Windows)
+func netrcCredentials(host string) (string, string, bool) {
+	netrcPath := os.Getenv("NETRC")
+	if netrcPath == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", "", false
+		}
+		netrcPath = filepath.Join(home, ".netrc")
+		if runtime.GOOS == "windows" {
+			if _, err := os.Stat(netrcPath); err != nil {
+				netrcPath = filepath.Join(home, "_netrc")
+			}
+		}
+	}
+
+	f, err := os.Open(netrcPath)
+	if err != nil {
+		return "", "", false
+	}
+	defer f.Close()
+
+	return findNetrc(parseNetrc(f), host)
+}
@@ -0,0 +1,52 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetrc(t *testing.T) {
+	entries := parseNetrc(strings.NewReader(`# comment
+machine nodeodm.example.com login alice password secret1
+machine localhost:3000
+	login bob
+	password secret2
+
+macdef init
+machine ignored login mallory password nope
+
+default login guest password guest
+`))
+
+	if login, password, ok := findNetrc(entries, "nodeodm.example.com:443"); !ok || login != "alice" || password != "secret1" {
+		t.Error("Wrong credentials for nodeodm.example.com:", login, password)
+	}
+	if login, _, _ := findNetrc(entries, "localhost:3000"); login != "bob" {
+		t.Error("Wrong credentials for localhost:3000:", login)
+	}
+	if login, _, _ := findNetrc(entries, "localhost:3001"); login != "guest" {
+		t.Error("localhost:3001 should have used the default entry:", login)
+	}
+	if login, _, _ := findNetrc(entries, "ignored"); login != "guest" {
+		t.Error("Macro definitions should be ignored:", login)
+	}
+
+	if _, _, ok := findNetrc(parseNetrc(strings.NewReader("machine a login b password c")), "other"); ok {
+		t.Error("There should be no credentials for other")
+	}
+}
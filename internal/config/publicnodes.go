@@ -17,13 +17,32 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	cloudodm "github.com/OpenDroneMap/CloudODM"
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
 )
 
+// DefaultPublicNodesURL is where the list of public nodes is retrieved from
+const DefaultPublicNodesURL = "https://raw.githubusercontent.com/OpenDroneMap/CloudODM/master/public_nodes.json"
+
+// PublicNodesURLEnv overrides DefaultPublicNodesURL (a URL or a local file path)
+const PublicNodesURLEnv = "ODM_PUBLIC_NODES_URL"
+
+// publicNodesTimeout limits how long to wait for the list of public
+// nodes before falling back to the cached or built-in list
+var publicNodesTimeout = 5 * time.Second
+
 type PublicNode struct {
 	Url        string `json:"url"`
 	Maintainer string `json:"maintainer"`
@@ -35,27 +54,211 @@ func (n PublicNode) String() string {
 	return fmt.Sprintf("%s", n.Url)
 }
 
+// publicNodesCache holds the validators of the cached list of public nodes
+type publicNodesCache struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+}
+
+// PublicNodesURL returns the location of the list of public nodes
+func PublicNodesURL() string {
+	if u := os.Getenv(PublicNodesURLEnv); u != "" {
+		return u
+	}
+	return DefaultPublicNodesURL
+}
+
+func publicNodesCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "odm"), nil
+}
+
+// GetPublicNodes retrieves the list of public nodes. The list is cached
+// locally and revalidated with ETag/Last-Modified; if it cannot be
+// retrieved, the cached copy is used, or the copy embedded in the binary.
 func GetPublicNodes() []PublicNode {
-	logger.Debug("Retrieving public nodes...")
+	logger.Trace("Retrieving public nodes...")
+	nodesURL := PublicNodesURL()
+
+	body, err := fetchPublicNodes(nodesURL)
+	if err == nil {
+		nodes, err := parsePublicNodes(body)
+		if err == nil {
+			return nodes
+		}
+		logger.Warn(err)
+	} else {
+		logger.Warn("Cannot retrieve public nodes from " + nodesURL + " (" + err.Error() + ")")
+	}
+
+	if body, err := readPublicNodesCache(nodesURL); err == nil {
+		if nodes, err := parsePublicNodes(body); err == nil {
+			logger.Info("Using cached list of public nodes")
+			return nodes
+		}
+	}
+
+	logger.Info("Using built-in list of public nodes")
+	nodes, err := parsePublicNodes(cloudodm.PublicNodesJSON)
+	if err != nil {
+		logger.Warn(err)
+		return []PublicNode{}
+	}
+	return nodes
+}
+
+func parsePublicNodes(body []byte) ([]PublicNode, error) {
 	nodes := []PublicNode{}
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		return nil, errors.New("Invalid JSON content: " + string(body))
+	}
+	return nodes, nil
+}
+
+func fetchPublicNodes(nodesURL string) ([]byte, error) {
+	if !strings.HasPrefix(nodesURL, "http://") && !strings.HasPrefix(nodesURL, "https://") {
+		// Local mirror
+		return ioutil.ReadFile(strings.TrimPrefix(nodesURL, "file://"))
+	}
 
-	resp, err := http.Get("https://raw.githubusercontent.com/OpenDroneMap/CloudODM/master/public_nodes.json")
+	req, err := http.NewRequest("GET", nodesURL, nil)
 	if err != nil {
-		logger.Info(err)
-		return nodes
+		return nil, err
+	}
+
+	cache := loadPublicNodesCache()
+	if cache.URL == nodesURL {
+		if cache.ETag != "" {
+			req.Header.Set("If-None-Match", cache.ETag)
+		}
+		if cache.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.LastModified)
+		}
+	}
+
+	client := http.Client{Timeout: publicNodesTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		logger.Trace("Public nodes not modified, using cached list")
+		return readPublicNodesCache(nodesURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Server returned status code: " + strconv.Itoa(resp.StatusCode))
+	}
+
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logger.Info(err)
-		return nodes
+		return nil, err
 	}
 
-	if err := json.Unmarshal(body, &nodes); err != nil {
-		logger.Info("Invalid JSON content: " + string(body))
-		return nodes
+	if _, err := parsePublicNodes(body); err == nil {
+		writePublicNodesCache(publicNodesCache{nodesURL, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")}, body)
 	}
 
-	return nodes
+	return body, nil
+}
+
+func loadPublicNodesCache() publicNodesCache {
+	cache := publicNodesCache{}
+
+	dir, err := publicNodesCacheDir()
+	if err != nil {
+		return cache
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "public_nodes.cache.json"))
+	if err != nil {
+		return cache
+	}
+	json.Unmarshal(data, &cache)
+
+	return cache
+}
+
+func readPublicNodesCache(nodesURL string) ([]byte, error) {
+	if cache := loadPublicNodesCache(); cache.URL != nodesURL {
+		return nil, errors.New("No cached list of public nodes for " + nodesURL)
+	}
+
+	dir, err := publicNodesCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(dir, "public_nodes.json"))
+}
+
+func writePublicNodesCache(cache publicNodesCache, body []byte) {
+	dir, err := publicNodesCacheDir()
+	if err != nil {
+		logger.Trace(err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Trace(err)
+		return
+	}
+
+	cacheData, _ := json.Marshal(cache)
+	if err := ioutil.WriteFile(filepath.Join(dir, "public_nodes.json"), body, 0644); err != nil {
+		logger.Trace(err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "public_nodes.cache.json"), cacheData, 0644); err != nil {
+		logger.Trace(err)
+		return
+	}
+
+	logger.Trace("Cached public nodes in " + dir)
+}
+
+// ChoosePublicNode probes all public nodes concurrently and picks the
+// healthiest one: nodes that are unreachable, fail authentication or
+// require a login are discarded, the others are ranked by free slots,
+// queue length and latency (see rankNodes). The reason for the choice
+// is printed. An error is returned if no node is available.
+func ChoosePublicNode(nodes []PublicNode) (PublicNode, error) {
+	statuses := make([]NodeStatus, len(nodes))
+
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n PublicNode) {
+			defer wg.Done()
+			statuses[i] = probeNode(strconv.Itoa(i), odm.Node{URL: n.Url})
+		}(i, n)
+	}
+	wg.Wait()
+
+	available := []NodeStatus{}
+	for i, s := range statuses {
+		switch {
+		case s.Online():
+			available = append(available, s)
+		case s.Err == odm.ErrAuthRequired:
+			logger.Debug(nodes[i].Url + ": requires authentication")
+		default:
+			logger.Debug(nodes[i].Url + ": " + s.Err.Error())
+		}
+	}
+
+	if len(available) == 0 {
+		return PublicNode{}, errors.New("None of the " + strconv.Itoa(len(nodes)) + " public nodes is available")
+	}
+
+	rankNodes(available)
+	chosen := available[0]
+	reason := strconv.Itoa(chosen.Info.TaskQueueCount) + " tasks in queue, " + strconv.Itoa(chosen.Info.FreeSlots()) +
+		" free slots, " + chosen.Latency.Round(time.Millisecond).String() + " latency"
+	logger.Info(strconv.Itoa(len(available)) + " of " + strconv.Itoa(len(nodes)) + " public nodes are available, picked the best one (" + reason + ")")
+
+	i, _ := strconv.Atoi(chosen.Name)
+	return nodes[i], nil
 }
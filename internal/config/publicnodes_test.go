@@ -0,0 +1,131 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetPublicNodes(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "odm-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	for _, env := range []string{"XDG_CACHE_HOME", "HOME"} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Setenv(env, cacheDir)
+	}
+	defer os.Unsetenv(PublicNodesURLEnv)
+
+	requests := 0
+	notModified := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"url": "https://mirror.example.com"}]`))
+	}))
+
+	os.Setenv(PublicNodesURLEnv, ts.URL)
+
+	nodes := GetPublicNodes()
+	if len(nodes) != 1 || nodes[0].Url != "https://mirror.example.com" {
+		t.Fatal("Cannot retrieve public nodes from mirror:", nodes)
+	}
+
+	nodes = GetPublicNodes()
+	if notModified != 1 || len(nodes) != 1 || nodes[0].Url != "https://mirror.example.com" {
+		t.Error("Cached public nodes should have been revalidated")
+	}
+
+	// Offline, use cache
+	ts.Close()
+	nodes = GetPublicNodes()
+	if len(nodes) != 1 || nodes[0].Url != "https://mirror.example.com" {
+		t.Error("Cached public nodes should have been used")
+	}
+
+	// Offline, no cache, use embedded copy
+	os.Setenv(PublicNodesURLEnv, "http://unknownhost:3000/public_nodes.json")
+	nodes = GetPublicNodes()
+	if len(nodes) == 0 || nodes[0].Url == "https://mirror.example.com" {
+		t.Error("Built-in public nodes should have been used")
+	}
+
+	// Unresponsive mirror, give up and use embedded copy
+	release := make(chan bool)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	defer func(timeout time.Duration) { publicNodesTimeout = timeout }(publicNodesTimeout)
+	publicNodesTimeout = 100 * time.Millisecond
+	os.Setenv(PublicNodesURLEnv, slow.URL)
+	started := time.Now()
+	if nodes = GetPublicNodes(); len(nodes) == 0 || time.Since(started) > 2*time.Second {
+		t.Error("Built-in public nodes should have been used after the timeout")
+	}
+
+	// Local file mirror
+	localFile := cacheDir + "/mirror.json"
+	ioutil.WriteFile(localFile, []byte(`[{"url": "http://local:3000"}, {"url": "http://local:3001"}]`), 0644)
+	os.Setenv(PublicNodesURLEnv, localFile)
+	if nodes = GetPublicNodes(); len(nodes) != 2 {
+		t.Error("Cannot read public nodes from a local file")
+	}
+}
+
+func TestChoosePublicNode(t *testing.T) {
+	busy := infoServer(`{"version":"2.0.0","taskQueueCount":3,"maxParallelTasks":1}`)
+	defer busy.Close()
+	idle := infoServer(`{"version":"2.0.0","taskQueueCount":0,"maxParallelTasks":1}`)
+	defer idle.Close()
+	login := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/info" {
+			w.Write([]byte(`{"loginUrl":"http://login"}`))
+		} else {
+			w.Write([]byte(`{"error":"Invalid authentication token"}`))
+		}
+	}))
+	defer login.Close()
+
+	offline := PublicNode{Url: "http://unknownhost:3000"}
+
+	chosen, err := ChoosePublicNode([]PublicNode{offline, {Url: busy.URL}, {Url: login.URL}, {Url: idle.URL}})
+	if err != nil || chosen.Url != idle.URL {
+		t.Error("The idle node should have been chosen, got", chosen.Url, err)
+	}
+
+	chosen, err = ChoosePublicNode([]PublicNode{offline, {Url: login.URL}, {Url: busy.URL}})
+	if err != nil || chosen.Url != busy.URL {
+		t.Error("Nodes requiring login should be discarded, got", chosen.Url, err)
+	}
+
+	if _, err := ChoosePublicNode([]PublicNode{offline, {Url: login.URL}}); err == nil {
+		t.Error("Should fail when no node is available")
+	}
+}
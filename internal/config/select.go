@@ -0,0 +1,136 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+)
+
+// AutoNodeName is the node name that selects the best available node
+const AutoNodeName = "auto"
+
+// GroupPrefix marks a node name as a group of nodes sharing a tag (e.g. @gpu)
+const GroupPrefix = "@"
+
+// IsNodeGroup checks whether a node name refers to more than one node
+// ("auto" or a @tag group)
+func IsNodeGroup(name string) bool {
+	return name == AutoNodeName || strings.HasPrefix(name, GroupPrefix)
+}
+
+// GroupMembers returns the names of the nodes a group refers to: all nodes
+// for "auto", the nodes tagged with tag for "@tag".
+func (c Configuration) GroupMembers(group string) ([]string, error) {
+	if group == AutoNodeName {
+		return c.NodeNames(), nil
+	}
+
+	tag := strings.TrimPrefix(group, GroupPrefix)
+	names := c.NodesWithTag(tag)
+	if len(names) == 0 {
+		return nil, errors.New("No nodes are tagged with " + tag + ". Tag one with ./odm node tag add <name> " + tag)
+	}
+	return names, nil
+}
+
+// NodeStatus is the result of querying a node's /info
+type NodeStatus struct {
+	Name    string
+	Node    odm.Node
+	Info    *odm.InfoResponse
+	Latency time.Duration
+	Err     error
+}
+
+// Online returns whether the node answered /info successfully
+func (s NodeStatus) Online() bool {
+	return s.Err == nil && s.Info != nil
+}
+
+// ProbeNodes queries /info on all the given nodes in parallel. Results
+// are returned in the same order as names.
+func (c Configuration) ProbeNodes(names []string) []NodeStatus {
+	statuses := make([]NodeStatus, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		statuses[i].Name = name
+
+		node, err := c.GetNode(name)
+		if err != nil {
+			statuses[i].Err = err
+			continue
+		}
+		statuses[i].Node = *node
+
+		wg.Add(1)
+		go func(s *NodeStatus) {
+			defer wg.Done()
+			*s = probeNode(s.Name, s.Node)
+		}(&statuses[i])
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+func probeNode(name string, node odm.Node) NodeStatus {
+	s := NodeStatus{Name: name, Node: node}
+
+	start := time.Now()
+	s.Info, s.Err = node.Info()
+	s.Latency = time.Since(start)
+	s.Err = node.CheckAuthentication(s.Err)
+
+	return s
+}
+
+// rankNodes sorts online nodes from the most to the least suitable: nodes
+// with more free processing slots come first, then nodes with shorter
+// queues, then nodes with lower latency.
+func rankNodes(statuses []NodeStatus) {
+	sort.SliceStable(statuses, func(i, j int) bool {
+		a, b := statuses[i].Info, statuses[j].Info
+		if a.FreeSlots() != b.FreeSlots() {
+			return a.FreeSlots() > b.FreeSlots()
+		}
+		if a.TaskQueueCount != b.TaskQueueCount {
+			return a.TaskQueueCount < b.TaskQueueCount
+		}
+		return statuses[i].Latency < statuses[j].Latency
+	})
+}
+
+// SelectNodes returns the nodes among names that are online and can process
+// imagesCount images, ordered from the most to the least suitable (see rankNodes).
+func (c Configuration) SelectNodes(names []string, imagesCount int) []NodeStatus {
+	candidates := []NodeStatus{}
+	for _, s := range c.ProbeNodes(names) {
+		if s.Online() && s.Info.MaxImages >= imagesCount {
+			candidates = append(candidates, s)
+		}
+	}
+
+	rankNodes(candidates)
+
+	return candidates
+}
@@ -0,0 +1,63 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/OpenDroneMap/CloudODM/internal/odm"
+)
+
+func infoServer(info string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(info))
+	}))
+}
+
+func TestSelectNodes(t *testing.T) {
+	busy := infoServer(`{"version":"2.0.0","taskQueueCount":4,"maxParallelTasks":2,"maxImages":0}`)
+	defer busy.Close()
+	idle := infoServer(`{"version":"2.0.0","taskQueueCount":0,"maxParallelTasks":2,"maxImages":0}`)
+	defer idle.Close()
+	small := infoServer(`{"version":"2.0.0","taskQueueCount":0,"maxParallelTasks":4,"maxImages":10}`)
+	defer small.Close()
+
+	c := NewConfiguration("")
+	c.Nodes["busy"] = odm.Node{URL: busy.URL}
+	c.Nodes["idle"] = odm.Node{URL: idle.URL}
+	c.Nodes["small"] = odm.Node{URL: small.URL}
+	c.Nodes["offline"] = odm.Node{URL: "http://unknownhost:3000"}
+
+	candidates := c.SelectNodes(c.NodeNames(), 100)
+	if len(candidates) != 2 {
+		t.Fatal("Expected 2 candidates, got", len(candidates))
+	}
+	if candidates[0].Name != "idle" || candidates[1].Name != "busy" {
+		t.Error("Wrong order:", candidates[0].Name, candidates[1].Name)
+	}
+
+	candidates = c.SelectNodes(c.NodeNames(), 5)
+	if len(candidates) != 3 || candidates[0].Name != "small" {
+		t.Error("small should have been picked first")
+	}
+
+	statuses := c.ProbeNodes([]string{"offline", "missing"})
+	if statuses[0].Online() || statuses[1].Online() {
+		t.Error("Nodes should not be online")
+	}
+}
@@ -16,8 +16,12 @@
 package fs
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
 )
 
 // FileExists checks if a file path exists
@@ -70,3 +74,103 @@ func DirectoryFilesCount(dirPath string) (int, error) {
 
 	return len(files), nil
 }
+
+// CleanDirectory removes all the contents of a directory, except for the
+// files named in keep, but not the directory itself. It refuses to clean
+// the directories rejected by CanCleanDirectory.
+func CleanDirectory(dirPath string, keep ...string) error {
+	if err := CanCleanDirectory(dirPath); err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(absPath)
+	if err != nil {
+		return err
+	}
+
+	keepNames := map[string]bool{}
+	for _, name := range keep {
+		keepNames[name] = true
+	}
+
+	for _, f := range files {
+		if keepNames[f.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(absPath, f.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CanCleanDirectory returns an error if dirPath should not be cleaned. As a
+// safety measure the filesystem root, the user's home directory, the current
+// working directory (or any of its parents) and symbolic links are rejected.
+func CanCleanDirectory(dirPath string) error {
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return err
+	}
+
+	fileInfo, err := os.Lstat(absPath)
+	if err != nil {
+		return err
+	}
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		return errors.New("refusing to clean " + dirPath + ": it is a symbolic link")
+	}
+	if !fileInfo.IsDir() {
+		return errors.New("refusing to clean " + dirPath + ": not a directory")
+	}
+
+	if filepath.Dir(absPath) == absPath {
+		return errors.New("refusing to clean " + dirPath + ": it is the filesystem root")
+	}
+	if home, err := homedir.Dir(); err == nil && isSamePath(home, absPath) {
+		return errors.New("refusing to clean " + dirPath + ": it is the home directory")
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		for p := cwd; ; p = filepath.Dir(p) {
+			if isSamePath(p, absPath) {
+				return errors.New("refusing to clean " + dirPath + ": it contains the current working directory")
+			}
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateSymlink atomically points the symbolic link at linkPath to target,
+// replacing any existing link.
+func UpdateSymlink(target string, linkPath string) error {
+	if fileInfo, err := os.Lstat(linkPath); err == nil && fileInfo.Mode()&os.ModeSymlink == 0 {
+		return errors.New(linkPath + " exists and is not a symbolic link")
+	}
+
+	tmpLink := linkPath + ".tmp"
+	os.Remove(tmpLink)
+	if err := os.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpLink, linkPath)
+}
+
+func isSamePath(a string, b string) bool {
+	a, errA := filepath.EvalSymlinks(a)
+	b, errB := filepath.EvalSymlinks(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return filepath.Clean(a) == filepath.Clean(b)
+}
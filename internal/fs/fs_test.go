@@ -0,0 +1,97 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-clean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0644)
+
+	if err := CleanDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := DirectoryFilesCount(dir); count != 0 {
+		t.Error("Directory should be empty, has", count, "files")
+	}
+	if !IsDirectory(dir) {
+		t.Error("Directory itself should not have been removed")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "keep.txt"), []byte("k"), 0644)
+	if err := CleanDirectory(dir, "keep.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := DirectoryFilesCount(dir); count != 1 || !IsFile(filepath.Join(dir, "keep.txt")) {
+		t.Error("Only keep.txt should have been left, have", count, "files")
+	}
+	os.Remove(filepath.Join(dir, "keep.txt"))
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(dir, link); err == nil {
+		if CleanDirectory(link) == nil {
+			t.Error("Should not be able to clean a symbolic link")
+		}
+	}
+
+	if CleanDirectory("/") == nil {
+		t.Error("Should not be able to clean the filesystem root")
+	}
+	if CleanDirectory(".") == nil {
+		t.Error("Should not be able to clean the current working directory")
+	}
+}
+
+func TestUpdateSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "odm-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "first"), 0755)
+	os.Mkdir(filepath.Join(dir, "second"), 0755)
+	link := filepath.Join(dir, "latest")
+
+	if err := UpdateSymlink("first", link); err != nil {
+		t.Skip("symbolic links not supported:", err)
+	}
+	if err := UpdateSymlink("second", link); err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(link); target != "second" {
+		t.Error("Link should point to second, points to", target)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644)
+	if UpdateSymlink("second", filepath.Join(dir, "file")) == nil {
+		t.Error("Should not replace a regular file")
+	}
+}
@@ -41,7 +41,7 @@ func Unzip(zipFilePath string, destDirectory string) ([]string, error) {
 	}
 	defer r.Close()
 
-	showProgress := !logger.QuietFlag && len(r.File) > 0
+	showProgress := logger.ShowProgress() && len(r.File) > 0
 	if showProgress {
 		bar = pb.New(len(r.File)).SetUnits(pb.U_NO).SetRefreshRate(time.Millisecond * 10)
 		bar.Start()
@@ -25,6 +25,11 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
+// IsInteractive checks whether the user can be prompted for input
+func IsInteractive() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
 func GetUsernamePassword() (username string, password string) {
 	reader := bufio.NewReader(os.Stdin)
 	username = ""
@@ -43,3 +48,45 @@ func GetUsernamePassword() (username string, password string) {
 
 	return username, password
 }
+
+// Prompt asks the user for a non-empty value
+func Prompt(label string) string {
+	reader := bufio.NewReader(os.Stdin)
+	value := ""
+	for len(value) == 0 {
+		fmt.Print(label + ": ")
+		value, _ = reader.ReadString('\n')
+		value = strings.TrimSpace(value)
+	}
+
+	return value
+}
+
+// PromptPassword asks the user for a password, without echoing it
+func PromptPassword(label string) string {
+	password := ""
+	for len(password) == 0 {
+		fmt.Print(label + ": ")
+		bytePassword, _ := terminal.ReadPassword(int(syscall.Stdin))
+		fmt.Println()
+		password = string(bytePassword)
+	}
+
+	return password
+}
+
+// GetRegistrationInfo asks the user for the details of a new account
+func GetRegistrationInfo() (email string, username string, password string) {
+	email = Prompt("Enter email")
+	username = Prompt("Enter username")
+
+	for {
+		password = PromptPassword("Enter password")
+		if PromptPassword("Confirm password") == password {
+			break
+		}
+		fmt.Println("Passwords do not match, try again.")
+	}
+
+	return email, username, password
+}
@@ -0,0 +1,154 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Status values of an entry, besides the odm.Stage values
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// Entry describes a dataset processed by odm
+type Entry struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Input   string    `json:"input"`
+	Output  string    `json:"output"`
+	Node    string    `json:"node,omitempty"`
+	NodeURL string    `json:"nodeUrl,omitempty"`
+	UUID    string    `json:"uuid,omitempty"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+}
+
+// Done checks whether the entry has reached a final status
+func (e Entry) Done() bool {
+	return e.Status == StatusCompleted || e.Status == StatusFailed || e.Status == StatusCanceled
+}
+
+// Journal is an append-only log of entries, one JSON object per line.
+// Each update of an entry is appended; the last line for an ID wins.
+type Journal struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open returns the journal stored at path
+func Open(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// Default returns the journal stored in the home directory
+func Default() (*Journal, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return Open(filepath.Join(home, ".odm_tasks.jsonl")), nil
+}
+
+// Path returns the path of the journal file
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append records the current state of an entry. Updated
+// is set to the current time if it's zero.
+func (j *Journal) Append(e Entry) error {
+	if e.Updated.IsZero() {
+		e.Updated = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Entries returns the latest state of every entry, in the order
+// they were first recorded. Lines that cannot be parsed are skipped.
+func (j *Journal) Entries() ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return []Entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+	index := map[string]int{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		e := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.ID == "" {
+			continue
+		}
+		if i, ok := index[e.ID]; ok {
+			entries[i] = e
+		} else {
+			index[e.ID] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// Get returns the latest state of an entry
+func (j *Journal) Get(id string) (*Entry, bool) {
+	entries, err := j.Entries()
+	if err != nil {
+		return nil, false
+	}
+	for _, e := range entries {
+		if e.ID == id {
+			return &e, true
+		}
+	}
+	return nil, false
+}
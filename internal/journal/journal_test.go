@@ -0,0 +1,65 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "odm")
+	defer os.RemoveAll(dir)
+
+	j := Open(filepath.Join(dir, "tasks.jsonl"))
+
+	entries, err := j.Entries()
+	if err != nil || len(entries) != 0 {
+		t.Error("Expected empty journal")
+	}
+
+	j.Append(Entry{ID: "1", Input: "a", Status: StatusPending})
+	j.Append(Entry{ID: "2", Input: "b", Status: StatusPending})
+	j.Append(Entry{ID: "1", Input: "a", UUID: "uuid-a", Status: StatusCompleted})
+
+	// Corrupted lines are ignored
+	f, _ := os.OpenFile(j.Path(), os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString("{not json\n")
+	f.Close()
+
+	entries, err = j.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("Expected 2 entries")
+	}
+	if entries[0].ID != "1" || entries[0].UUID != "uuid-a" || !entries[0].Done() {
+		t.Error("Expected entry 1 to be updated")
+	}
+	if entries[1].ID != "2" || entries[1].Done() {
+		t.Error("Expected entry 2 to be pending")
+	}
+
+	if e, ok := j.Get("2"); !ok || e.Input != "b" {
+		t.Error("Cannot get entry 2")
+	}
+	if _, ok := j.Get("3"); ok {
+		t.Error("Entry 3 should not exist")
+	}
+}
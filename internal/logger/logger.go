@@ -1,44 +1,327 @@
 package logger
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-// Verbose output
-var VerboseFlag bool
+// LevelEnv overrides the log level when no level flag is passed
+const LevelEnv = "ODM_LOG_LEVEL"
+
+// Level is the severity of a log message
+type Level int
 
-// DebugFlag sets debug output
-var DebugFlag bool
+const (
+	TraceLevel Level = iota
+	DebugLevel
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
 
-// QuietFlag supresses all output
-var QuietFlag bool
+var levelNames = []string{"trace", "debug", "info", "warn", "error"}
 
-// Debug message (if DebugFlag is enabled)
-func Debug(a ...interface{}) {
-	if DebugFlag {
-		fmt.Println(a...)
+func (l Level) String() string {
+	if l < TraceLevel || l > ErrorLevel {
+		return "unknown"
 	}
+	return levelNames[l]
 }
 
-// Verbose message (if VerboseFlag is enabled)
-func Verbose(a ...interface{}) {
-	if VerboseFlag || DebugFlag {
-		fmt.Println(a...)
+// ParseLevel converts a level name (trace, debug, info, warn, error) to a Level
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		name = "warn"
 	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return InfoLevel, errors.New("Invalid log level " + name + " (valid levels are: " + strings.Join(levelNames, ", ") + ")")
 }
 
-// Info message
-func Info(a ...interface{}) {
-	if !QuietFlag {
-		fmt.Println(a...)
+// Fields are key/value pairs attached to messages (e.g. node, uuid, file)
+type Fields map[string]interface{}
+
+// Settings control how messages are printed
+type Settings struct {
+	Level Level
+
+	// Format is either "text" or "json" (newline-delimited JSON events)
+	Format string
+
+	// Color is one of "auto" (only when printing to a terminal), "always" or "never"
+	Color string
+
+	// Timestamps prefixes text messages with the current time
+	Timestamps bool
+}
+
+// output is shared by all loggers created with With
+type output struct {
+	mu       sync.Mutex
+	settings Settings
+	stdout   io.Writer
+	stderr   io.Writer
+	color    bool
+}
+
+// Logger prints leveled messages with fields. Info messages are the
+// regular output of the program and go to stdout; all other levels go
+// to stderr. In JSON mode everything goes to stdout.
+type Logger struct {
+	out      *output
+	fields   Fields
+	minLevel Level
+}
+
+var std = &Logger{
+	out: &output{
+		settings: Settings{Level: InfoLevel, Format: "text", Color: "never"},
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	},
+	fields: Fields{},
+}
+
+// Configure changes the settings of the logger
+func Configure(settings Settings) error {
+	if settings.Format != "text" && settings.Format != "json" {
+		return errors.New("Invalid output format " + settings.Format + " (valid formats are: text, json)")
+	}
+
+	color := false
+	switch settings.Color {
+	case "always":
+		color = true
+	case "never":
+	case "auto", "":
+		color = os.Getenv("NO_COLOR") == "" && terminal.IsTerminal(int(os.Stderr.Fd()))
+	default:
+		return errors.New("Invalid color setting " + settings.Color + " (valid settings are: auto, always, never)")
+	}
+
+	std.out.mu.Lock()
+	defer std.out.mu.Unlock()
+	std.out.settings = settings
+	std.out.color = color && settings.Format == "text"
+	return nil
+}
+
+// LevelFromEnv returns the level set in ODM_LOG_LEVEL, or def if not set
+func LevelFromEnv(def Level) (Level, error) {
+	if name := os.Getenv(LevelEnv); name != "" {
+		level, err := ParseLevel(name)
+		if err != nil {
+			return def, errors.New(LevelEnv + ": " + err.Error())
+		}
+		return level, nil
 	}
+	return def, nil
+}
+
+// Enabled checks whether messages of a level are printed
+func Enabled(level Level) bool {
+	return std.out.settings.Level <= level
+}
+
+// JSON checks whether output should be printed as JSON events
+func JSON() bool {
+	return std.out.settings.Format == "json"
+}
+
+var progressDisabled bool
+
+// DisableProgress hides progress bars, for example when
+// several tasks are processed at the same time
+func DisableProgress() {
+	progressDisabled = true
 }
 
-//Error message and exit
-func Error(a ...interface{}) {
-	if !QuietFlag {
-		fmt.Println(a...)
+// ShowProgress checks whether progress bars should be displayed
+func ShowProgress() bool {
+	return Enabled(InfoLevel) && !JSON() && !progressDisabled
+}
+
+// tokenRe matches the value of token query parameters
+var tokenRe = regexp.MustCompile(`([?&]token=)[^&#\s"]*`)
+
+// Redact hides tokens in URLs (or any text containing URLs)
+func Redact(s string) string {
+	return tokenRe.ReplaceAllString(s, "${1}REDACTED")
+}
+
+// With returns a logger that adds fields to every message
+func With(fields Fields) *Logger {
+	return std.With(fields)
+}
+
+// With returns a logger that adds fields to every message
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
 	}
+	return &Logger{out: l.out, fields: merged, minLevel: l.minLevel}
+}
+
+// WithLevel returns a logger that only prints messages of at least
+// level (e.g. to hide the info messages of background tasks). JSON
+// events are still printed.
+func (l *Logger) WithLevel(level Level) *Logger {
+	return &Logger{out: l.out, fields: l.fields, minLevel: level}
+}
+
+var levelColors = map[Level]string{
+	TraceLevel: "\033[90m",
+	DebugLevel: "\033[36m",
+	WarnLevel:  "\033[33m",
+	ErrorLevel: "\033[31m",
+}
+
+func (l *Logger) log(level Level, a ...interface{}) {
+	o := l.out
+	if o.settings.Level > level || l.minLevel > level {
+		return
+	}
+	message := strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+
+	if o.settings.Format == "json" {
+		fields := Fields{"message": message}
+		eventType := "error"
+		if level != ErrorLevel {
+			eventType = "log"
+			fields["level"] = level.String()
+		}
+		l.printEvent(eventType, fields)
+		return
+	}
+
+	var sb strings.Builder
+	if o.settings.Timestamps {
+		sb.WriteString(time.Now().Format(time.RFC3339) + " ")
+	}
+	if level != InfoLevel {
+		tag := "[" + strings.ToUpper(level.String()) + "]"
+		if o.color {
+			tag = levelColors[level] + tag + "\033[0m"
+		}
+		sb.WriteString(tag + " ")
+	}
+	sb.WriteString(message)
+
+	// Info messages are meant for the user, fields are only
+	// displayed for diagnostic messages
+	if level != InfoLevel {
+		keys := []string{}
+		for k := range l.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sb.WriteString(" " + k + "=" + fmt.Sprint(l.fields[k]))
+		}
+	}
+
+	w := o.stderr
+	if level == InfoLevel {
+		w = o.stdout
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintln(w, Redact(sb.String()))
+}
+
+func (l *Logger) printEvent(eventType string, fields Fields) {
+	event := Fields{}
+	for k, v := range l.fields {
+		event[k] = v
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+	event["type"] = eventType
+	event["time"] = time.Now().Format(time.RFC3339Nano)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		data, _ = json.Marshal(Fields{"type": "error", "message": err.Error()})
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	fmt.Fprintln(l.out.stdout, Redact(string(data)))
+}
+
+// Event reports something that scripts might want to parse. With
+// --output-format json it's printed as a JSON object with the event type,
+// the fields and the message (if not empty), regardless of the log level;
+// otherwise the message (if not empty) is printed as an Info message.
+func (l *Logger) Event(eventType string, message string, fields Fields) {
+	if l.out.settings.Format == "json" {
+		event := Fields{}
+		for k, v := range fields {
+			event[k] = v
+		}
+		if message != "" {
+			event["message"] = message
+		}
+		l.printEvent(eventType, event)
+	} else if message != "" {
+		l.Info(message)
+	}
+}
+
+// Trace message, for following the program step by step
+func (l *Logger) Trace(a ...interface{}) { l.log(TraceLevel, a...) }
+
+// Debug message, useful when troubleshooting
+func (l *Logger) Debug(a ...interface{}) { l.log(DebugLevel, a...) }
+
+// Info message, the regular output of the program
+func (l *Logger) Info(a ...interface{}) { l.log(InfoLevel, a...) }
+
+// Warn message, for problems that don't stop the program
+func (l *Logger) Warn(a ...interface{}) { l.log(WarnLevel, a...) }
+
+// Error message and exit
+func (l *Logger) Error(a ...interface{}) {
+	l.log(ErrorLevel, a...)
 	os.Exit(1)
 }
+
+// Event reports something that scripts might want to parse
+func Event(eventType string, message string, fields Fields) {
+	std.Event(eventType, message, fields)
+}
+
+// Trace message
+func Trace(a ...interface{}) { std.Trace(a...) }
+
+// Debug message
+func Debug(a ...interface{}) { std.Debug(a...) }
+
+// Info message
+func Info(a ...interface{}) { std.Info(a...) }
+
+// Warn message
+func Warn(a ...interface{}) { std.Warn(a...) }
+
+// Error message and exit
+func Error(a ...interface{}) { std.Error(a...) }
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func captureOutput(settings Settings) (*bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	Configure(settings)
+	std.out.stdout = &stdout
+	std.out.stderr = &stderr
+	return &stdout, &stderr
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARNING"); err != nil || l != WarnLevel {
+		t.Error("Expected warn level")
+	}
+	if l, err := ParseLevel("trace"); err != nil || l != TraceLevel {
+		t.Error("Expected trace level")
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected error for invalid level")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	stdout, stderr := captureOutput(Settings{Level: InfoLevel, Format: "text", Color: "never"})
+
+	Debug("hidden")
+	Info("hello")
+	With(Fields{"uuid": "abc", "file": "1.jpg"}).Warn("careful")
+	Info("http://localhost/info?token=secret")
+
+	if stdout.String() != "hello\nhttp://localhost/info?token=REDACTED\n" {
+		t.Error("Unexpected stdout: " + stdout.String())
+	}
+	if stderr.String() != "[WARN] careful file=1.jpg uuid=abc\n" {
+		t.Error("Unexpected stderr: " + stderr.String())
+	}
+	if Enabled(DebugLevel) || !ShowProgress() {
+		t.Error("Debug should be disabled and progress shown")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	stdout, stderr := captureOutput(Settings{Level: ErrorLevel, Format: "json", Color: "never"})
+	defer captureOutput(Settings{Level: InfoLevel, Format: "text", Color: "never"})
+
+	Info("hidden")
+	With(Fields{"uuid": "abc"}).Event("output", "line", Fields{"n": 1})
+
+	if stderr.Len() != 0 {
+		t.Error("Expected nothing on stderr")
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatal("Expected a single event: " + stdout.String())
+	}
+
+	event := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event["type"] != "output" || event["message"] != "line" || event["uuid"] != "abc" || event["n"] != float64(1) {
+		t.Error("Unexpected event: " + lines[0])
+	}
+	if ShowProgress() {
+		t.Error("Progress should not be shown in JSON mode")
+	}
+
+	if err := Configure(Settings{Format: "xml"}); err == nil {
+		t.Error("Expected error for invalid format")
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package metrics implements counters and histograms that can be
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w io.Writer)
+	snapshot(values map[string]float64)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry used by the package-level functions
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write prints all metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics to Prometheus
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+// Snapshot returns the current value of every series, keyed by
+// name and labels. Histograms are reported as _count and _sum.
+func (r *Registry) Snapshot() map[string]float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	values := map[string]float64{}
+	for _, m := range r.metrics {
+		m.snapshot(values)
+	}
+	return values
+}
+
+// desc is shared by all metric types
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (d desc) header(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, metricType)
+}
+
+// key identifies a series by its label values
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic("metrics: " + d.name + " expects " + strconv.Itoa(len(d.labels)) + " label values")
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// series formats the name of a series, e.g. name{status="failed",le="10"}
+func (d desc) series(suffix string, labelValues []string, extra ...string) string {
+	pairs := []string{}
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escape(labelValues[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return d.name + suffix
+	}
+	return d.name + suffix + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a value that only goes up, e.g. the number of bytes uploaded
+type Counter struct {
+	desc
+	mu          sync.Mutex
+	values      map[string]float64
+	labelValues map[string][]string
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// NewCounter creates a counter with optional label names
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:        desc{name, help, labels},
+		values:      map[string]float64{},
+		labelValues: map[string][]string{},
+	}
+	if len(labels) == 0 {
+		c.labelValues[""] = nil
+	}
+	r.register(c)
+	return c
+}
+
+// Add increases the counter by v for the given label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += v
+	c.labelValues[key] = labelValues
+}
+
+// Inc increases the counter by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, "counter")
+	for _, k := range sortedKeys(c.labelValues) {
+		fmt.Fprintln(w, c.series("", c.labelValues[k]), formatValue(c.values[k]))
+	}
+}
+
+func (c *Counter) snapshot(values map[string]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, lv := range c.labelValues {
+		values[c.series("", lv)] = c.values[k]
+	}
+}
+
+// Histogram counts observations (e.g. durations) in buckets
+type Histogram struct {
+	desc
+	buckets     []float64
+	mu          sync.Mutex
+	counts      map[string][]uint64
+	sums        map[string]float64
+	labelValues map[string][]string
+}
+
+// NewHistogram creates a histogram in the default registry
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram creates a histogram with the given upper bounds
+// (in increasing order) and optional label names
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:        desc{name, help, labels},
+		buckets:     buckets,
+		counts:      map[string][]uint64{},
+		sums:        map[string]float64{},
+		labelValues: map[string][]string{},
+	}
+	if len(labels) == 0 {
+		h.labelValues[""] = nil
+		h.counts[""] = make([]uint64, len(buckets)+1)
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets)+1)
+		h.counts[key] = counts
+		h.labelValues[key] = labelValues
+	}
+
+	// The last count is the +Inf bucket
+	i := sort.SearchFloat64s(h.buckets, v)
+	counts[i]++
+	h.sums[key] += v
+}
+
+// Count returns the number of observations for the given label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var total uint64
+	for _, c := range h.counts[key] {
+		total += c
+	}
+	return total
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, "histogram")
+	for _, k := range sortedKeys(h.labelValues) {
+		lv := h.labelValues[k]
+		var cumulative uint64
+		for i, c := range h.counts[k] {
+			cumulative += c
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+			fmt.Fprintln(w, h.series("_bucket", lv, "le", le), cumulative)
+		}
+		fmt.Fprintln(w, h.series("_sum", lv), formatValue(h.sums[k]))
+		fmt.Fprintln(w, h.series("_count", lv), cumulative)
+	}
+}
+
+func (h *Histogram) snapshot(values map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, lv := range h.labelValues {
+		var total uint64
+		for _, c := range h.counts[k] {
+			total += c
+		}
+		values[h.series("_count", lv)] = float64(total)
+		values[h.series("_sum", lv)] = h.sums[k]
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "A counter")
+	byStatus := r.NewCounter("test_failures_total", "A counter with labels", "status")
+
+	c.Add(2)
+	c.Inc()
+	byStatus.Inc("failed")
+	byStatus.Inc("canceled")
+	byStatus.Inc("failed")
+
+	if c.Value() != 3 {
+		t.Error("Expected 3")
+	}
+	if byStatus.Value("failed") != 2 || byStatus.Value("canceled") != 1 {
+		t.Error("Expected failures by status")
+	}
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	expected := `# HELP test_total A counter
+# TYPE test_total counter
+test_total 3
+# HELP test_failures_total A counter with labels
+# TYPE test_failures_total counter
+test_failures_total{status="canceled"} 1
+test_failures_total{status="failed"} 2
+`
+	if buf.String() != expected {
+		t.Error("Unexpected output:\n" + buf.String())
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("test_seconds", "A histogram", []float64{1, 10})
+
+	h.Observe(0.5)
+	h.Observe(1)
+	h.Observe(5)
+	h.Observe(100)
+
+	if h.Count() != 4 {
+		t.Error("Expected 4 observations")
+	}
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	for _, line := range []string{
+		`test_seconds_bucket{le="1"} 2`,
+		`test_seconds_bucket{le="10"} 3`,
+		`test_seconds_bucket{le="+Inf"} 4`,
+		`test_seconds_sum 106.5`,
+		`test_seconds_count 4`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Error("Expected " + line + " in:\n" + buf.String())
+		}
+	}
+
+	values := r.Snapshot()
+	if values["test_seconds_count"] != 4 || values["test_seconds_sum"] != 106.5 {
+		t.Error("Unexpected snapshot")
+	}
+}
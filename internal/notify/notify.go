@@ -0,0 +1,282 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package notify sends messages (email, chat webhooks, desktop
+// notifications) when tasks finish.
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier types
+const (
+	TypeEmail   = "email"
+	TypeWebhook = "webhook"
+	TypeDesktop = "desktop"
+)
+
+// Events that trigger notifications
+var Events = []string{"completed", "failed", "canceled"}
+
+// timeout for sending a notification
+const timeout = 30 * time.Second
+
+// Config describes where to send notifications
+type Config struct {
+	Type string `json:"type"`
+
+	// Events to notify (all if empty)
+	Events []string `json:"events,omitempty"`
+
+	// URL of a webhook (Slack, Teams or compatible)
+	URL string `json:"url,omitempty"`
+
+	// Email settings
+	To       []string `json:"to,omitempty"`
+	From     string   `json:"from,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+}
+
+// Notification describes a task that finished
+type Notification struct {
+	Status         string
+	UUID           string
+	Node           string
+	Output         string
+	ProcessingTime time.Duration
+	Error          string
+}
+
+// Title is a one line summary of the notification
+func (n Notification) Title() string {
+	return "ODM task " + n.Status
+}
+
+// Message describes the task, with a link to the outputs
+func (n Notification) Message() string {
+	lines := []string{"Task " + n.UUID + " on " + n.Node + " " + n.Status + "."}
+	if n.ProcessingTime > 0 {
+		lines = append(lines, "Processing time: "+n.ProcessingTime.Round(time.Second).String())
+	}
+	if n.Error != "" {
+		lines = append(lines, "Error: "+n.Error)
+	}
+	if n.Output != "" && n.Status == "completed" && n.Error == "" {
+		lines = append(lines, "Results: "+fileURL(n.Output))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return "file://" + path
+}
+
+// Validate checks that the settings of a notifier are complete
+func (c Config) Validate() error {
+	for _, e := range c.Events {
+		found := false
+		for _, valid := range Events {
+			found = found || e == valid
+		}
+		if !found {
+			return errors.New("Invalid event " + e + " (valid events are: " + strings.Join(Events, ", ") + ")")
+		}
+	}
+
+	switch c.Type {
+	case TypeEmail:
+		if len(c.To) == 0 || c.Host == "" {
+			return errors.New("Email notifications require a recipient and an SMTP host")
+		}
+	case TypeWebhook:
+		if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+			return errors.New("Invalid webhook URL: " + c.URL)
+		}
+	case TypeDesktop:
+	default:
+		return errors.New("Invalid notifier type " + c.Type + " (valid types are: " + TypeEmail + ", " + TypeWebhook + ", " + TypeDesktop + ")")
+	}
+	return nil
+}
+
+// Wants checks whether the notifier should be used for a status
+func (c Config) Wants(status string) bool {
+	if len(c.Events) == 0 {
+		return true
+	}
+	for _, e := range c.Events {
+		if e == status {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the notifier
+func (c Config) String() string {
+	switch c.Type {
+	case TypeEmail:
+		return c.Type + " to " + strings.Join(c.To, ", ") + " via " + c.Host + ":" + strconv.Itoa(c.port())
+	case TypeWebhook:
+		return c.Type + " " + c.URL
+	default:
+		return c.Type
+	}
+}
+
+// Send delivers a notification
+func (c Config) Send(n Notification) error {
+	switch c.Type {
+	case TypeEmail:
+		return c.sendEmail(n)
+	case TypeWebhook:
+		return c.sendWebhook(n)
+	case TypeDesktop:
+		return sendDesktop(n)
+	default:
+		return c.Validate()
+	}
+}
+
+func (c Config) port() int {
+	if c.Port == 0 {
+		return 587
+	}
+	return c.Port
+}
+
+func (c Config) sendEmail(n Notification) error {
+	from := c.From
+	if from == "" {
+		from = c.Username
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(c.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + n.Title() + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Message(), "\n", "\r\n") + "\r\n")
+
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.port()))
+	var conn net.Conn
+	var err error
+	if c.port() == 465 {
+		// Implicit TLS
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{ServerName: c.Host})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.Host}); err != nil {
+			return err
+		}
+	}
+	if c.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range c.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// sendWebhook posts a JSON message with a "text" field, which is
+// understood by Slack, Microsoft Teams and Mattermost incoming webhooks
+func (c Config) sendWebhook(n Notification) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":           "*" + n.Title() + "*\n" + n.Message(),
+		"status":         n.Status,
+		"uuid":           n.UUID,
+		"node":           n.Node,
+		"output":         n.Output,
+		"processingTime": n.ProcessingTime.Seconds(),
+		"error":          n.Error,
+	})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Post(c.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New("Webhook returned " + resp.Status)
+	}
+	return nil
+}
+
+func sendDesktop(n Notification) error {
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		return errors.New("Desktop notifications require notify-send")
+	}
+	return exec.Command("notify-send", "--app-name", "odm", n.Title(), n.Message()).Run()
+}
@@ -0,0 +1,87 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := []Config{
+		{Type: TypeDesktop},
+		{Type: TypeWebhook, URL: "https://hooks.slack.com/services/x"},
+		{Type: TypeEmail, To: []string{"me@example.com"}, Host: "smtp.example.com", Events: []string{"failed"}},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	invalid := []Config{
+		{Type: "sms"},
+		{Type: TypeWebhook, URL: "hooks.slack.com"},
+		{Type: TypeEmail, Host: "smtp.example.com"},
+		{Type: TypeDesktop, Events: []string{"started"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Error("Expected " + c.String() + " to be invalid")
+		}
+	}
+}
+
+func TestWants(t *testing.T) {
+	if !(Config{}).Wants("completed") {
+		t.Error("Expected all events by default")
+	}
+	c := Config{Events: []string{"failed", "canceled"}}
+	if c.Wants("completed") || !c.Wants("failed") {
+		t.Error("Expected only failed and canceled")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	c := Config{Type: TypeWebhook, URL: server.URL}
+	err := c.Send(Notification{
+		Status:         "completed",
+		UUID:           "uuid-1",
+		Node:           "http://localhost:3000",
+		Output:         "/data/output",
+		ProcessingTime: 90 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, _ := payload["text"].(string)
+	for _, s := range []string{"ODM task completed", "uuid-1", "1h30m0s", "file:///data/output"} {
+		if !strings.Contains(text, s) {
+			t.Error("Expected " + s + " in " + text)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import "github.com/OpenDroneMap/CloudODM/internal/metrics"
+
+var (
+	uploadedBytes = metrics.NewCounter("odm_upload_bytes_total",
+		"Bytes of images uploaded to processing nodes")
+	uploadRetries = metrics.NewHistogram("odm_upload_retries",
+		"Retries needed to upload each file",
+		[]float64{0, 1, 2, 5, 10})
+	queueWaitTime = metrics.NewHistogram("odm_task_queue_wait_seconds",
+		"Time tasks waited in the node queue before processing started",
+		[]float64{1, 10, 60, 300, 900, 1800, 3600, 7200})
+	processingSeconds = metrics.NewHistogram("odm_task_processing_seconds",
+		"Processing time of finished tasks as reported by the node, by status",
+		[]float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400}, "status")
+	downloadedBytes = metrics.NewCounter("odm_download_bytes_total",
+		"Bytes of results downloaded from processing nodes")
+	downloadThroughput = metrics.NewHistogram("odm_download_throughput_bytes_per_second",
+		"Average throughput of each download",
+		[]float64{1e5, 1e6, 5e6, 1e7, 5e7, 1e8})
+	taskFailures = metrics.NewCounter("odm_task_failures_total",
+		"Tasks that did not complete, by status", "status")
+)
@@ -17,13 +17,14 @@ package odm
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
 	"mime/multipart"
-	"net/http"
 	"net/url"
 	"os"
 	"sort"
@@ -39,16 +40,53 @@ import (
 // ErrUnauthorized means a response was not authorized
 var ErrUnauthorized = errors.New("Unauthorized")
 
+// ErrAssetNotFound means the node cannot provide an asset of a task
+var ErrAssetNotFound = errors.New("Asset not found")
+
+// ErrTooManyRequests means the node asked to slow down (HTTP 429)
+var ErrTooManyRequests = errors.New("Too many requests")
+
 // ErrAuthRequired means authorization is required
 var ErrAuthRequired = errors.New("Auth Required")
 
 type InfoResponse struct {
-	Version   string `json:"version"`
-	MaxImages int    `json:"maxImages"`
+	Version          string `json:"version"`
+	TaskQueueCount   int    `json:"taskQueueCount"`
+	MaxParallelTasks int    `json:"maxParallelTasks"`
+	MaxImages        int    `json:"maxImages"`
+	TotalMemory      int64  `json:"totalMemory"`
+	AvailableMemory  int64  `json:"availableMemory"`
+	CPUCores         int    `json:"cpuCores"`
+	Engine           string `json:"engine"`
+	EngineVersion    string `json:"engineVersion"`
 
 	Error string `json:"error"`
 }
 
+// ClusterTag marks a node as ClusterODM. ClusterODM answers /info with the
+// combined capacity and the engine of its nodes, so it usually can't be told
+// apart from a NodeODM node and should be tagged.
+const ClusterTag = "clusterodm"
+
+// IsClusterODM checks whether /info identifies a ClusterODM instance in
+// the engine or version fields (see ClusterTag)
+func (i InfoResponse) IsClusterODM() bool {
+	return strings.Contains(strings.ToLower(i.Engine), ClusterTag) ||
+		strings.Contains(strings.ToLower(i.Version), ClusterTag)
+}
+
+// FreeSlots returns the number of tasks the node can start without queuing
+func (i InfoResponse) FreeSlots() int {
+	maxParallelTasks := i.MaxParallelTasks
+	if maxParallelTasks < 1 {
+		maxParallelTasks = 1
+	}
+	if i.TaskQueueCount >= maxParallelTasks {
+		return 0
+	}
+	return maxParallelTasks - i.TaskQueueCount
+}
+
 type OptionResponse struct {
 	Domain interface{} `json:"domain"`
 	Help   string      `json:"help"`
@@ -64,12 +102,15 @@ type AuthInfoResponse struct {
 }
 
 type StatusCode struct {
-	Code int `json:"code"`
+	Code         int    `json:"code"`
+	ErrorMessage string `json:"errorMessage"`
 }
 
 type TaskInfoResponse struct {
 	ProcessingTime int        `json:"processingTime"`
 	Status         StatusCode `json:"status"`
+
+	Error string `json:"error"`
 }
 
 type ApiActionResponse struct {
@@ -84,9 +125,17 @@ type TaskNewResponse struct {
 
 // Node is a NodeODM processing node
 type Node struct {
-	URL   string `json:"url"`
-	Token string `json:"token"`
+	URL   string   `json:"url"`
+	Token string   `json:"token"`
+	Tags  []string `json:"tags,omitempty"`
 
+	Transport *Transport `json:"transport,omitempty"`
+
+	// AuthHeader sends the token in an Authorization header
+	// instead of the token query parameter
+	AuthHeader bool `json:"authHeader,omitempty"`
+
+	ctx                context.Context
 	_debugUnauthorized bool
 }
 
@@ -94,6 +143,16 @@ func (n Node) String() string {
 	return n.URL
 }
 
+// HasTag checks whether the node has been tagged with tag
+func (n Node) HasTag(tag string) bool {
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // URLFor builds a URL path
 func (n Node) URLFor(path string) string {
 	u, err := url.ParseRequestURI(n.URL + path)
@@ -101,7 +160,7 @@ func (n Node) URLFor(path string) string {
 		return ""
 	}
 	q := u.Query()
-	if len(n.Token) > 0 {
+	if len(n.Token) > 0 && !n.AuthHeader {
 		q.Add("token", n.Token)
 	}
 	if n._debugUnauthorized {
@@ -113,9 +172,9 @@ func (n Node) URLFor(path string) string {
 
 func (n Node) apiGET(path string) ([]byte, error) {
 	url := n.URLFor(path)
-	logger.Debug("GET: " + url)
+	logger.Trace("GET: " + logger.Redact(url))
 
-	resp, err := http.Get(url)
+	resp, err := n.httpGet(url)
 	if err != nil {
 		return nil, err
 	}
@@ -138,15 +197,15 @@ func (n Node) apiGET(path string) ([]byte, error) {
 
 func (n Node) apiPOST(path string, values map[string]string) ([]byte, error) {
 	targetURL := n.URLFor(path)
-	logger.Debug("POST: " + targetURL)
+	logger.Trace("POST: " + logger.Redact(targetURL))
 
 	formData := url.Values{}
 	for k, v := range values {
 		formData.Set(k, v)
-		logger.Debug(k + ": " + v)
+		logger.Trace(k + ": " + v)
 	}
 
-	resp, err := http.Post(targetURL, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
+	resp, err := n.httpPost(targetURL, "application/x-www-form-urlencoded", strings.NewReader(formData.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -164,6 +223,14 @@ func (n Node) apiPOST(path string, values map[string]string) ([]byte, error) {
 	return body, nil
 }
 
+// apiError converts an error message returned by the API to an error
+func apiError(message string) error {
+	if strings.HasPrefix(message, "Invalid authentication token") {
+		return ErrUnauthorized
+	}
+	return errors.New(message)
+}
+
 // Info GET: /info
 func (n Node) Info() (*InfoResponse, error) {
 	res := InfoResponse{}
@@ -176,10 +243,7 @@ func (n Node) Info() (*InfoResponse, error) {
 	}
 
 	if res.Error != "" {
-		if strings.HasPrefix(res.Error, "Invalid authentication token") {
-			return nil, ErrUnauthorized
-		}
-		return nil, errors.New(res.Error)
+		return nil, apiError(res.Error)
 	}
 
 	if res.MaxImages == 0 {
@@ -189,6 +253,42 @@ func (n Node) Info() (*InfoResponse, error) {
 	return &res, nil
 }
 
+// AuthRequired checks whether the node requires a token
+func (n Node) AuthRequired() (bool, error) {
+	anonymous := n
+	anonymous.Token = ""
+
+	_, err := anonymous.Info()
+	if err == ErrUnauthorized {
+		return true, nil
+	}
+	return false, err
+}
+
+// SupportsAuthHeader checks whether the node requires a token and
+// accepts it in an Authorization header
+func (n Node) SupportsAuthHeader() (bool, error) {
+	if n.Token == "" {
+		return false, nil
+	}
+
+	required, err := n.AuthRequired()
+	if err != nil || !required {
+		return false, err
+	}
+
+	withHeader := n
+	withHeader.AuthHeader = true
+	if _, err := withHeader.Info(); err != nil {
+		if err == ErrUnauthorized {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 // Options GET: /options
 func (n Node) Options() ([]OptionResponse, error) {
 	options := []OptionResponse{}
@@ -218,6 +318,10 @@ func (n Node) TaskInfo(uuid string) (*TaskInfoResponse, error) {
 		return nil, err
 	}
 
+	if res.Error != "" {
+		return nil, apiError(res.Error)
+	}
+
 	return &res, nil
 }
 
@@ -229,6 +333,10 @@ func (n Node) TaskOutput(uuid string, line int) ([]string, error) {
 		return nil, err
 	}
 	if err := json.Unmarshal(body, &res); err != nil {
+		errRes := ApiActionResponse{}
+		if json.Unmarshal(body, &errRes) == nil && errRes.Error != "" {
+			return nil, apiError(errRes.Error)
+		}
 		return nil, err
 	}
 
@@ -245,19 +353,45 @@ func (n Node) TaskDownload(uuid string, asset string, outputFile string) error {
 	}
 	defer out.Close()
 
-	resp, err := http.Get(n.URLFor("/task/" + uuid + "/download/" + asset))
+	resp, err := n.httpGet(n.URLFor("/task/" + uuid + "/download/" + asset))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == 401 {
+		return ErrUnauthorized
+	}
+	if resp.StatusCode == 404 {
+		return ErrAssetNotFound
+	}
+	if resp.StatusCode != 200 {
+		return errors.New("Server returned status code: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	// Errors are returned as JSON instead of the asset
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		res := ApiActionResponse{}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if json.Unmarshal(body, &res) == nil && res.Error != "" {
+			if strings.HasPrefix(res.Error, "Invalid asset") {
+				return ErrAssetNotFound
+			}
+			return apiError(res.Error)
+		}
+		return errors.New("Unexpected response: " + string(body))
+	}
+
 	totalBytes, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 	if err != nil {
 		totalBytes = 0
-		logger.Debug("Warning: Content-length not set")
+		logger.Debug("Content-length not set")
 	}
 
-	showProgress := !logger.QuietFlag && totalBytes > 0
+	showProgress := logger.ShowProgress() && totalBytes > 0
 	if showProgress {
 		bar = pb.New64(totalBytes).SetUnits(pb.U_BYTES).SetRefreshRate(time.Millisecond * 10)
 		bar.Start()
@@ -267,6 +401,8 @@ func (n Node) TaskDownload(uuid string, asset string, outputFile string) error {
 	var writer io.Writer
 	if bar != nil {
 		writer = io.MultiWriter(out, bar)
+	} else if logger.JSON() {
+		writer = io.MultiWriter(out, &downloadProgress{uuid: uuid, asset: asset, total: totalBytes})
 	} else {
 		writer = out
 	}
@@ -302,22 +438,40 @@ func (n Node) TaskCancel(uuid string) error {
 	return nil
 }
 
+// writeFields adds form fields to a multipart form, sorted by name
+func writeFields(mpw *multipart.Writer, fields map[string]string) error {
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := mpw.WriteField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // TaskNewInit POST: /task/new/init
-func (n Node) TaskNewInit(jsonOptions []byte) TaskNewResponse {
+// fields are the form fields of the task (options, webhook, ...)
+func (n Node) TaskNewInit(fields map[string]string) TaskNewResponse {
 	var err error
 	reqBody := &bytes.Buffer{}
 	mpw := multipart.NewWriter(reqBody)
-	mpw.WriteField("skipPostProcessing", "true")
-	mpw.WriteField("options", string(jsonOptions))
+	if err = writeFields(mpw, fields); err != nil {
+		return TaskNewResponse{"", err.Error()}
+	}
 	if err = mpw.Close(); err != nil {
 		return TaskNewResponse{"", err.Error()}
 	}
 
-	resp, err := http.Post(n.URLFor("/task/new/init"), mpw.FormDataContentType(), reqBody)
-	defer resp.Body.Close()
+	resp, err := n.httpPost(n.URLFor("/task/new/init"), mpw.FormDataContentType(), reqBody)
 	if err != nil {
 		return TaskNewResponse{"", err.Error()}
 	}
+	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -333,7 +487,8 @@ func (n Node) TaskNewInit(jsonOptions []byte) TaskNewResponse {
 }
 
 // TaskNewUpload POST: /task/new/upload/{uuid}
-func (n Node) TaskNewUpload(file string, uuid string, bar *pb.ProgressBar) error {
+// The bytes sent are also written to counter, if not nil
+func (n Node) TaskNewUpload(file string, uuid string, bar *pb.ProgressBar, counter io.Writer) error {
 	var f *os.File
 	var fi os.FileInfo
 	var err error
@@ -365,8 +520,13 @@ func (n Node) TaskNewUpload(file string, uuid string, bar *pb.ProgressBar) error
 		if bar != nil {
 			part = io.MultiWriter(part, bar)
 		}
+		if counter != nil {
+			part = io.MultiWriter(part, counter)
+		}
 
-		if _, err = io.Copy(part, f); err != nil {
+		written, copyErr := io.Copy(part, f)
+		uploadedBytes.Add(float64(written))
+		if err = copyErr; err != nil {
 			return
 		}
 
@@ -375,11 +535,14 @@ func (n Node) TaskNewUpload(file string, uuid string, bar *pb.ProgressBar) error
 		}
 	}()
 
-	resp, err := http.Post(n.URLFor("/task/new/upload/"+uuid), mpw.FormDataContentType(), r)
+	resp, err := n.httpPost(n.URLFor("/task/new/upload/"+uuid), mpw.FormDataContentType(), r)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == 429 {
+		return ErrTooManyRequests
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return err
@@ -437,13 +600,23 @@ type LoginResponse struct {
 	Token string `json:"token"`
 }
 
-func (n Node) TryLogin(username string, password string) (token string, err error) {
+// AuthInfo GET: /auth/info
+func (n Node) AuthInfo() (*AuthInfoResponse, error) {
 	res := AuthInfoResponse{}
 	body, err := n.apiGET("/auth/info")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (n Node) TryLogin(username string, password string) (token string, err error) {
+	res, err := n.AuthInfo()
+	if err != nil {
 		return "", err
 	}
 
@@ -458,11 +631,11 @@ func (n Node) TryLogin(username string, password string) (token string, err erro
 			username, password = odmio.GetUsernamePassword()
 		}
 
-		logger.Debug("")
-		logger.Debug("POST: " + res.LoginUrl)
+		logger.Trace("")
+		logger.Trace("POST: " + res.LoginUrl)
 
 		formData, _ := json.Marshal(map[string]string{"username": username, "password": password})
-		resp, err := http.Post(res.LoginUrl, "application/json", bytes.NewBuffer(formData))
+		resp, err := n.httpPost(res.LoginUrl, "application/json", bytes.NewBuffer(formData))
 		if err != nil {
 			return "", err
 		}
@@ -489,7 +662,109 @@ func (n Node) TryLogin(username string, password string) (token string, err erro
 		return res.Token, nil
 	}
 
-	// TODO: support for res.RegisterUrl
+	if res.RegisterUrl != "" {
+		return "", errors.New("Cannot login. If you don't have an account, create one with ./odm register")
+	}
 
 	return "", errors.New("Cannot login")
 }
+
+// RegistrationError holds the validation errors returned by a register URL
+type RegistrationError struct {
+	Messages []string
+}
+
+func (e *RegistrationError) Error() string {
+	return "Registration failed: " + strings.Join(e.Messages, "; ")
+}
+
+// parseRegistrationErrors extracts error messages from a register URL
+// response, either {"error": "message"} or {"field": ["message", ...]}
+func parseRegistrationErrors(body []byte) []string {
+	res := map[string]interface{}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil
+	}
+
+	keys := []string{}
+	for k := range res {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	messages := []string{}
+	for _, k := range keys {
+		switch v := res[k].(type) {
+		case string:
+			if k == "error" || k == "message" || k == "detail" {
+				messages = append(messages, v)
+			} else {
+				messages = append(messages, k+": "+v)
+			}
+		case []interface{}:
+			for _, m := range v {
+				messages = append(messages, k+": "+fmt.Sprint(m))
+			}
+		}
+	}
+
+	return messages
+}
+
+// Register creates an account using the node's register URL
+// (see AuthInfo). On validation errors, a *RegistrationError is returned.
+func (n Node) Register(registerURL string, email string, username string, password string) error {
+	logger.Trace("POST: " + registerURL)
+
+	formData, _ := json.Marshal(map[string]string{"email": email, "username": username, "password": password})
+	resp, err := n.httpPost(registerURL, "application/json", bytes.NewBuffer(formData))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		if messages := parseRegistrationErrors(body); len(messages) > 0 {
+			return &RegistrationError{messages}
+		}
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return errors.New("Register URL returned status code: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	// Some servers report errors with a 200 status code
+	res := ApiActionResponse{}
+	if json.Unmarshal(body, &res) == nil && res.Error != "" {
+		return &RegistrationError{[]string{res.Error}}
+	}
+
+	return nil
+}
+
+// downloadProgress reports download_progress events (at most one per second)
+type downloadProgress struct {
+	uuid     string
+	asset    string
+	total    int64
+	written  int64
+	lastSent time.Time
+}
+
+func (d *downloadProgress) Write(p []byte) (int, error) {
+	d.written += int64(len(p))
+	if time.Since(d.lastSent) >= time.Second || d.written == d.total {
+		d.lastSent = time.Now()
+		logger.Event("download_progress", "", logger.Fields{
+			"uuid":       d.uuid,
+			"asset":      d.asset,
+			"bytes":      d.written,
+			"totalBytes": d.total,
+		})
+	}
+	return len(p), nil
+}
@@ -16,6 +16,12 @@
 package odm
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -44,3 +50,124 @@ func TestAPI(t *testing.T) {
 		t.Error("Error should have been ErrUnauthorized")
 	}
 }
+
+func TestExpiredToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") == "valid" {
+			w.Write([]byte(`["line"]`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":"Invalid authentication token: token expired"}`))
+	}))
+	defer ts.Close()
+
+	node := Node{URL: ts.URL, Token: "expired"}
+
+	if _, err := node.TaskInfo("uuid"); err != ErrUnauthorized {
+		t.Error("TaskInfo should have returned ErrUnauthorized, returned", err)
+	}
+	if _, err := node.TaskOutput("uuid", 0); err != ErrUnauthorized {
+		t.Error("TaskOutput should have returned ErrUnauthorized, returned", err)
+	}
+
+	dir, err := ioutil.TempDir("", "odm-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := node.TaskDownload("uuid", "all.zip", filepath.Join(dir, "all.zip")); err != ErrUnauthorized {
+		t.Error("TaskDownload should have returned ErrUnauthorized, returned", err)
+	}
+
+	node.Token = "valid"
+	if lines, err := node.TaskOutput("uuid", 0); err != nil || len(lines) != 1 {
+		t.Error("TaskOutput should have succeeded", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), `"username":"taken"`) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"username":["A user with that username already exists."],"password":["This password is too short."]}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer ts.Close()
+
+	node := Node{URL: ts.URL}
+
+	if err := node.Register(ts.URL, "user@example.com", "new", "password"); err != nil {
+		t.Error("Registration should have succeeded", err)
+	}
+
+	err := node.Register(ts.URL, "user@example.com", "taken", "pw")
+	regErr, ok := err.(*RegistrationError)
+	if !ok {
+		t.Fatal("Registration should have returned a RegistrationError, returned", err)
+	}
+	if len(regErr.Messages) != 2 || regErr.Messages[0] != "password: This password is too short." {
+		t.Error("Unexpected validation errors:", regErr.Messages)
+	}
+}
+
+func TestAuthHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") == "secret" || r.Header.Get("Authorization") == "Bearer secret" {
+			w.Write([]byte(`{"version":"2.0.0"}`))
+			return
+		}
+		w.Write([]byte(`{"error":"Invalid authentication token"}`))
+	}))
+	defer ts.Close()
+
+	node := Node{URL: ts.URL, Token: "secret"}
+	if !strings.Contains(node.URLFor("/info"), "secret") {
+		t.Error("Token should be in the URL")
+	}
+	if supported, err := node.SupportsAuthHeader(); !supported || err != nil {
+		t.Error("Node should support the Authorization header", err)
+	}
+
+	node.AuthHeader = true
+	if strings.Contains(node.URLFor("/info"), "secret") {
+		t.Error("Token should not be in the URL")
+	}
+	if _, err := node.Info(); err != nil {
+		t.Error("Cannot authenticate with the Authorization header", err)
+	}
+
+	offlineNode := Node{URL: "http://unknownhost:3000", Token: "secret"}
+	if _, err := offlineNode.Info(); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Error("Token should be redacted from errors", err)
+	}
+}
+
+func TestIsClusterODM(t *testing.T) {
+	payloads := map[string]bool{
+		// NodeODM 2.2 with an unlimited number of images
+		`{"version":"2.2.0","taskQueueCount":0,"totalMemory":16624066560,"availableMemory":13186469888,"cpuCores":8,"maxImages":null,"maxParallelTasks":2,"engineVersion":"3.3.0","engine":"odm"}`: false,
+		`{"version":"1.5.3","taskQueueCount":1,"maxImages":1000,"maxParallelTasks":4,"engineVersion":"3.3.0","engine":"clusterodm"}`:                                                               true,
+		`{"version":"1.5.3-ClusterODM","taskQueueCount":1,"maxImages":1000,"maxParallelTasks":4,"engineVersion":"3.3.0","engine":"odm"}`:                                                           true,
+	}
+
+	for payload, cluster := range payloads {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(payload))
+		}))
+
+		info, err := Node{URL: ts.URL}.Info()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.IsClusterODM() != cluster {
+			t.Error("IsClusterODM should be", cluster, "for", payload)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+)
+
+const (
+	taskLogFile   = "task_output.log"
+	runRecordFile = "run.json"
+)
+
+// runRecord describes a processing run. It's saved as run.json
+// in the output directory, next to the results.
+type runRecord struct {
+	Node           string     `json:"node"`
+	Name           string     `json:"name,omitempty"`
+	UUID           string     `json:"uuid"`
+	Options        []Option   `json:"options"`
+	Images         int        `json:"images"`
+	Status         string     `json:"status"`
+	Error          string     `json:"error,omitempty"`
+	Started        time.Time  `json:"started"`
+	TaskCreated    time.Time  `json:"taskCreated"`
+	Finished       *time.Time `json:"finished,omitempty"`
+	ProcessingTime int        `json:"processingTime"`
+
+	mu   sync.Mutex
+	path string
+}
+
+func newRunRecord(node Node, name string, options []Option, images int, outputPath string, started time.Time) *runRecord {
+	return &runRecord{
+		Node:    logger.Redact(node.URL),
+		Name:    name,
+		Options: options,
+		Images:  images,
+		Started: started,
+		path:    filepath.Join(outputPath, runRecordFile),
+	}
+}
+
+// update changes the record with f and saves it. Errors are logged,
+// since the record shouldn't interrupt processing.
+func (r *runRecord) update(f func(r *runRecord)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f(r)
+
+	data, err := json.MarshalIndent(r, "", " ")
+	if err == nil {
+		err = ioutil.WriteFile(r.path, data, 0644)
+	}
+	if err != nil {
+		logger.Debug("Cannot write " + r.path + ": " + err.Error())
+	}
+}
+
+// finish sets the final status of the run and saves it
+func (r *runRecord) finish(status string, errMessage string) {
+	r.update(func(r *runRecord) {
+		now := time.Now()
+		r.Status = status
+		r.Error = errMessage
+		r.Finished = &now
+	})
+}
+
+// taskLog saves the task output, with timestamps, to task_output.log
+type taskLog struct {
+	f *os.File
+}
+
+func newTaskLog(outputPath string) *taskLog {
+	path := filepath.Join(outputPath, taskLogFile)
+	f, err := os.Create(path)
+	if err != nil {
+		logger.Debug("Cannot create " + path + ": " + err.Error())
+		return &taskLog{}
+	}
+	return &taskLog{f}
+}
+
+func (l *taskLog) Write(line string) {
+	if l.f == nil {
+		return
+	}
+	if _, err := l.f.WriteString(time.Now().Format(time.RFC3339) + " " + line + "\n"); err != nil {
+		logger.Debug("Cannot write to " + l.f.Name() + ": " + err.Error())
+		l.Close()
+	}
+}
+
+func (l *taskLog) Close() {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
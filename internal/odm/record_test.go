@@ -0,0 +1,65 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "odm")
+	defer os.RemoveAll(dir)
+
+	node := Node{URL: "http://localhost:3000", Token: "secret"}
+	record := newRunRecord(node, "site1", []Option{{"fast-orthophoto", true}}, 3, dir, time.Now())
+	record.update(func(r *runRecord) {
+		r.UUID = "abc"
+	})
+	record.finish(StatusName(STATUS_COMPLETED), "")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, runRecordFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("run.json should not contain the token")
+	}
+
+	saved := runRecord{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.UUID != "abc" || saved.Name != "site1" || saved.Status != "completed" || saved.Images != 3 || saved.Finished == nil || len(saved.Options) != 1 {
+		t.Error("Unexpected run record: " + string(data))
+	}
+
+	log := newTaskLog(dir)
+	log.Write("first line")
+	log.Write("second line")
+	log.Close()
+
+	data, _ = ioutil.ReadFile(filepath.Join(dir, taskLogFile))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], " second line") {
+		t.Error("Unexpected task log: " + string(data))
+	}
+}
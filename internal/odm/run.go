@@ -16,25 +16,33 @@
 package odm
 
 import (
+	"archive/zip"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
-	"net/http"
 	"os"
-	"os/signal"
 	"path"
-	"syscall"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/OpenDroneMap/CloudODM/internal/fs"
 
 	"github.com/OpenDroneMap/CloudODM/internal/logger"
+	"github.com/OpenDroneMap/CloudODM/internal/notify"
 
 	"github.com/cheggaaa/pb"
 )
 
+// ErrCanceled is returned by Run when the context is canceled
+var ErrCanceled = errors.New("Task canceled")
+
 type fileUpload struct {
 	filename string
 	retries  int
@@ -46,28 +54,27 @@ type fileUploadResult struct {
 	retries  int
 }
 
-func singleUpload(node Node, files []string, jsonOptions []byte) TaskNewResponse {
-	var f *os.File
-	var fi os.FileInfo
-	var err error
+func singleUpload(node Node, files []string, fields map[string]string) (string, error) {
 	var bar *pb.ProgressBar
 	var res TaskNewResponse
 
-	showProgress := !logger.QuietFlag
+	showProgress := logger.ShowProgress()
 
 	if showProgress {
 		var totalBytes int64
 
 		// Calculate total upload size
 		for _, file := range files {
-			if fi, err = os.Stat(file); err != nil {
-				logger.Error(err)
+			fi, err := os.Stat(file)
+			if err != nil {
+				return "", err
 			}
 			totalBytes += fi.Size()
 		}
 
 		bar = pb.New64(totalBytes).SetUnits(pb.U_BYTES).SetRefreshRate(time.Millisecond * 10)
 		bar.Start()
+		defer bar.Finish()
 	}
 
 	r, w := io.Pipe()
@@ -75,87 +82,127 @@ func singleUpload(node Node, files []string, jsonOptions []byte) TaskNewResponse
 
 	// Pipe work, stream file contents
 	go func() {
-		var part io.Writer
-		defer w.Close()
-		defer f.Close()
-
-		for _, file := range files {
-			if f, err = os.Open(file); err != nil {
-				logger.Error(err)
-			}
-			if fi, err = f.Stat(); err != nil {
-				logger.Error(err)
-			}
-
-			if part, err = mpw.CreateFormFile("images", fi.Name()); err != nil {
-				logger.Error(err)
+		for i, file := range files {
+			if err := writeFormFile(mpw, file, bar); err != nil {
+				w.CloseWithError(err)
+				return
 			}
 
-			if showProgress {
-				bar.Prefix("[" + fi.Name() + "]")
-			}
-
-			part = io.MultiWriter(part, bar)
-
-			if _, err = io.Copy(part, f); err != nil {
-				logger.Error(err)
-			}
+			logger.Event("upload_progress", "", logger.Fields{
+				"file":          file,
+				"filesUploaded": i + 1,
+				"filesTotal":    len(files),
+			})
 		}
 
-		mpw.WriteField("skipPostProcessing", "true")
-		mpw.WriteField("options", string(jsonOptions))
-
-		if err = mpw.Close(); err != nil {
-			logger.Error(err)
+		if err := writeFields(mpw, fields); err != nil {
+			w.CloseWithError(err)
+			return
 		}
+
+		w.CloseWithError(mpw.Close())
 	}()
 
-	resp, err := http.Post(node.URLFor("/task/new"), mpw.FormDataContentType(), r)
+	resp, err := node.httpPost(node.URLFor("/task/new"), mpw.FormDataContentType(), r)
 	if err != nil {
-		logger.Error(err)
+		return "", err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logger.Error(err)
-	}
-
-	if showProgress {
-		bar.Finish()
+		return "", err
 	}
 
 	if err := json.Unmarshal(body, &res); err != nil {
-		logger.Error(err)
+		return "", err
+	}
+	if res.Error != "" {
+		return "", errors.New(res.Error)
 	}
 
-	return res
+	return res.UUID, nil
 }
 
-func uploadWorker(id int, node Node, uuid string, barPool *pb.Pool, filesToProcess <-chan fileUpload, results chan<- fileUploadResult) {
-	var bar *pb.ProgressBar
+// writeFormFile adds file to a multipart form as an image
+func writeFormFile(mpw *multipart.Writer, file string, bar *pb.ProgressBar) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	if barPool != nil {
-		bar = pb.New64(0).SetUnits(pb.U_BYTES).SetRefreshRate(time.Millisecond * 10)
-		barPool.Add(bar)
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	part, err := mpw.CreateFormFile("images", fi.Name())
+	if err != nil {
+		return err
+	}
+
+	if bar != nil {
+		bar.Prefix("[" + fi.Name() + "]")
+		part = io.MultiWriter(part, bar)
 	}
 
+	written, err := io.Copy(part, f)
+	uploadedBytes.Add(float64(written))
+	return err
+}
+
+// uploadWorker uploads files until the queue is closed. If limiter is not
+// nil, it waits for a free connection before each upload. Bytes sent are
+// counted with counter, if not nil.
+func uploadWorker(id int, node Node, uuid string, barPool *pb.Pool, limiter *connectionLimiter, counter *byteCounter, filesToProcess <-chan fileUpload, results chan<- fileUploadResult) {
+	var bar *pb.ProgressBar
+
 	for f := range filesToProcess {
-		results <- fileUploadResult{f.filename, node.TaskNewUpload(f.filename, uuid, bar), f.retries}
+		if limiter != nil && !limiter.acquire() {
+			return
+		}
+
+		// Workers that are never used (when tuning the
+		// connections) don't get a progress bar
+		if barPool != nil && bar == nil {
+			bar = pb.New64(0).SetUnits(pb.U_BYTES).SetRefreshRate(time.Millisecond * 10)
+			barPool.Add(bar)
+		}
+
+		var w io.Writer // not a nil *byteCounter, which isn't a nil io.Writer
+		if counter != nil {
+			w = counter
+		}
+		err := node.TaskNewUpload(f.filename, uuid, bar, w)
+		if limiter != nil {
+			limiter.release()
+		}
+		if err == ErrTooManyRequests {
+			// Give the node some time before the file is retried
+			sleep(node.context(), uploadBackoff(f.retries))
+		}
+		results <- fileUploadResult{f.filename, err, f.retries}
 	}
 }
 
-func chunkedUpload(node Node, files []string, jsonOptions []byte, parallelUploads int, maxUploadRetries int) TaskNewResponse {
-	var err error
+// chunkedUpload creates a task and uploads files with parallelUploads
+// connections. With autoTune, parallelUploads is the maximum and the
+// number of connections is adjusted to the measured throughput.
+func chunkedUpload(node Node, files []string, fields map[string]string, parallelUploads int, maxUploadRetries int, autoTune bool) (string, error) {
 	var barPool *pb.Pool
 	var mainBar *pb.ProgressBar
-	var res TaskNewResponse
 
-	showProgress := !logger.QuietFlag
+	showProgress := logger.ShowProgress()
+
+	// Stop the workers when returning early
+	ctx, cancel := context.WithCancel(node.context())
+	defer cancel()
+	node = node.WithContext(ctx)
 
 	// Invoke /task/new/init
-	res = node.TaskNewInit(jsonOptions)
+	res := node.TaskNewInit(fields)
 	if res.Error != "" {
-		logger.Error(err)
+		return "", errors.New(res.Error)
 	}
 
 	if showProgress {
@@ -165,18 +212,36 @@ func chunkedUpload(node Node, files []string, jsonOptions []byte, parallelUpload
 	// Create workers
 	filesToProcess := make(chan fileUpload, len(files))
 	results := make(chan fileUploadResult, len(files))
+	defer close(filesToProcess)
+
+	var tuner *connectionTuner
+	var limiter *connectionLimiter
+	var counter *byteCounter
+	var tick <-chan time.Time
+	if autoTune {
+		tuner = newConnectionTuner(1, parallelUploads)
+		limiter = newConnectionLimiter(tuner.connections)
+		counter = &byteCounter{}
+		defer limiter.close()
+
+		ticker := time.NewTicker(tuneInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
 	for w := 1; w <= parallelUploads; w++ {
-		go uploadWorker(w, node, res.UUID, barPool, filesToProcess, results)
+		go uploadWorker(w, node, res.UUID, barPool, limiter, counter, filesToProcess, results)
 	}
 
 	if barPool != nil {
 		barPool.Start()
+		defer barPool.Stop()
 
 		mainBar = pb.New(len(files)).SetUnits(pb.U_NO).SetRefreshRate(time.Millisecond * 10)
 		mainBar.Format("[\x00#\x00\x00_\x00]")
 		mainBar.Prefix("Files Uploaded:")
 		mainBar.Start()
+		defer mainBar.Finish()
 	}
 
 	// Fill queue
@@ -186,168 +251,538 @@ func chunkedUpload(node Node, files []string, jsonOptions []byte, parallelUpload
 
 	// Wait
 	filesLeft := len(files)
+	intervalFailed := false
 	for filesLeft > 0 {
-		fur := <-results
+		var fur fileUploadResult
+		select {
+		case fur = <-results:
+		case <-tick:
+			// Nothing to measure if no bytes were sent (e.g. while waiting after a 429)
+			if intervalBytes := counter.reset(); intervalBytes > 0 || intervalFailed {
+				throughput := float64(intervalBytes) / tuneInterval.Seconds()
+				connections := tuner.next(throughput, intervalFailed)
+				limiter.setLimit(connections)
+				logger.Debug(fmt.Sprintf("Upload throughput: %.2f MB/s, using %d connections", throughput/1e6, connections))
+			}
+			intervalFailed = false
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 
 		if fur.err != nil {
+			intervalFailed = true
 			if fur.retries < maxUploadRetries {
 				// Retry
+				logger.With(logger.Fields{"file": fur.filename}).Debug("Upload failed (" + fur.err.Error() + "), retrying...")
 				filesToProcess <- fileUpload{fur.filename, fur.retries + 1}
 			} else {
-				logger.Error(errors.New("Cannot upload " + fur.filename + ", exceeded max retries (" + string(maxUploadRetries) + ")"))
+				uploadRetries.Observe(float64(fur.retries))
+				return "", errors.New("Cannot upload " + fur.filename + ", exceeded max retries (" + strconv.Itoa(maxUploadRetries) + ")")
 			}
 		} else {
+			uploadRetries.Observe(float64(fur.retries))
 			filesLeft--
 			if mainBar != nil {
 				mainBar.Set(len(files) - filesLeft)
 			}
+			logger.Event("upload_progress", "", logger.Fields{
+				"file":          fur.filename,
+				"filesUploaded": len(files) - filesLeft,
+				"filesTotal":    len(files),
+			})
 		}
 	}
-	close(filesToProcess)
-
-	if barPool != nil {
-		barPool.Stop()
-	}
-	if mainBar != nil {
-		mainBar.Finish()
-	}
 
 	// Commit
 	res = node.TaskNewCommit(res.UUID)
 	if res.Error != "" {
-		logger.Error(res.Error)
+		return "", errors.New(res.Error)
 	}
 
-	return res
+	return res.UUID, nil
 }
 
-// Run processes a dataset
-func Run(files []string, options []Option, node Node, outputPath string, parallelConnections int, maxUploadRetries int) {
-	var err error
+// Stage is a step in the processing of a dataset
+type Stage string
 
-	// Convert options to JSON
-	jsonOptions, err := json.Marshal(options)
+const (
+	StageUploading   Stage = "uploading"
+	StageProcessing  Stage = "processing"
+	StageDownloading Stage = "downloading"
+)
+
+// RunSettings controls how a dataset is processed
+type RunSettings struct {
+	// ParallelConnections is the number of upload connections, or
+	// the maximum if AutoConnections is set. Files are uploaded in
+	// a single request when it's 1 or less.
+	ParallelConnections int
+	AutoConnections     bool
+	MaxUploadRetries    int
+
+	// Reauthenticate is called to obtain a new token when the node
+	// rejects the current one (e.g. because it expired). If nil,
+	// unauthorized errors are fatal.
+	Reauthenticate func() (string, error)
+
+	// Logger prints the messages of the run. Defaults to the global logger.
+	Logger *logger.Logger
+
+	// OnStage is called when the run moves to another stage
+	OnStage func(stage Stage, uuid string)
+
+	// Webhook is a URL that the node calls when the task finishes
+	Webhook string
+
+	// Notifiers are sent a message when the task finishes
+	Notifiers map[string]notify.Config
+
+	// TaskName is the name of the task displayed by the node
+	TaskName string
+
+	// DateCreated overrides the creation date of the task (if not zero)
+	DateCreated time.Time
+
+	// PostProcessing asks the node to generate tiles and
+	// point cloud formats for the web after processing
+	PostProcessing bool
+
+	// Assets are downloaded in addition to all.zip
+	// (see PostProcessingAssets)
+	Assets []string
+
+	// CleanOutput removes the previous contents of the output directory
+	// after the results are downloaded, before extracting them
+	CleanOutput bool
+}
+
+// PostProcessingAssets can be downloaded when post processing is enabled
+var PostProcessingAssets = []string{"orthophoto_tiles.zip", "dsm_tiles.zip", "dtm_tiles.zip", "entwine_pointcloud"}
+
+// RunResult describes a task processed by Run
+type RunResult struct {
+	// UUID is empty if the task could not be created
+	UUID           string
+	Status         int
+	ProcessingTime int
+}
+
+// taskFields returns the form fields used to create a task
+func taskFields(jsonOptions []byte, settings RunSettings) map[string]string {
+	fields := map[string]string{
+		"skipPostProcessing": strconv.FormatBool(!settings.PostProcessing),
+		"options":            string(jsonOptions),
+	}
+	if settings.Webhook != "" {
+		fields["webhook"] = settings.Webhook
+	}
+	if settings.TaskName != "" {
+		fields["name"] = settings.TaskName
+	}
+	if !settings.DateCreated.IsZero() {
+		// Milliseconds since the epoch
+		fields["dateCreated"] = strconv.FormatInt(settings.DateCreated.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return fields
+}
+
+// refreshToken replaces the token of node after it has been rejected
+func refreshToken(node *Node, settings RunSettings, log *logger.Logger) error {
+	if settings.Reauthenticate == nil {
+		return errors.New("Cannot authenticate with the node (token expired?)")
+	}
+
+	log.Info("The node rejected the token (expired?), logging in again...")
+	token, err := settings.Reauthenticate()
 	if err != nil {
-		logger.Error(err)
+		return err
 	}
+	node.Token = token
 
-	var res TaskNewResponse
-	if parallelConnections <= 1 {
-		res = singleUpload(node, files, jsonOptions)
-	} else {
-		res = chunkedUpload(node, files, jsonOptions, parallelConnections, maxUploadRetries)
+	// The new token may be accepted in a different way
+	if supported, err := node.SupportsAuthHeader(); err == nil {
+		node.AuthHeader = supported
 	}
+	return nil
+}
 
-	// Handle error response from API
-	if res.Error != "" {
-		logger.Error(res.Error)
+// sleep waits for d, returning false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
 	}
+}
 
-	// We should have a UUID
-	uuid := res.UUID
-	logger.Info("Task UUID: " + uuid)
+// Run processes a dataset and downloads the results to outputPath.
+// If the task could not be created, the UUID of the result is empty
+// and another node can be tried. When ctx is canceled, the task is
+// canceled on the node and ErrCanceled is returned.
+func Run(ctx context.Context, files []string, options []Option, node Node, outputPath string, settings RunSettings) (*RunResult, error) {
+	result := &RunResult{}
+	record := newRunRecord(node, settings.TaskName, options, len(files), outputPath, time.Now())
+
+	log := settings.Logger
+	if log == nil {
+		log = logger.With(nil)
+	}
+	onStage := func(stage Stage) {
+		if settings.OnStage != nil {
+			settings.OnStage(stage, result.UUID)
+		}
+	}
 
-	info, err := node.TaskInfo(uuid)
+	// Convert options to JSON
+	jsonOptions, err := json.Marshal(options)
 	if err != nil {
-		logger.Error(err)
+		return result, err
 	}
 
-	// Catch CTRL+C
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-
-		logger.Info("Canceling task...")
+	onStage(StageUploading)
+	node = node.WithContext(ctx)
 
-		// Attempt to cancel task
-		retryCount := 0
-		retryLimit := 5
+	var uuid string
+	if settings.ParallelConnections <= 1 && !settings.AutoConnections {
+		uuid, err = singleUpload(node, files, taskFields(jsonOptions, settings))
+	} else {
+		uuid, err = chunkedUpload(node, files, taskFields(jsonOptions, settings), settings.ParallelConnections, settings.MaxUploadRetries, settings.AutoConnections)
+	}
+	if ctx.Err() != nil {
+		return result, ErrCanceled
+	}
+	if err != nil {
+		return result, err
+	}
 
-		for retryCount < retryLimit {
-			if err := node.TaskCancel(uuid); err != nil {
-				retryCount++
-				logger.Info(err)
-				time.Sleep(1 * time.Second)
-			} else {
-				break
-			}
-		}
+	// We should have a UUID
+	result.UUID = uuid
+	log = log.With(logger.Fields{"node": node.URL, "uuid": uuid})
+	if settings.TaskName != "" {
+		log.Event("task_created", "Task "+settings.TaskName+" created, UUID: "+uuid, logger.Fields{"name": settings.TaskName})
+	} else {
+		log.Event("task_created", "Task UUID: "+uuid, nil)
+	}
 
-		filesCount, err := fs.DirectoryFilesCount(outputPath)
-		if err == nil && fs.IsDirectory(outputPath) && filesCount == 0 {
-			os.Remove(outputPath)
-		}
+	record.update(func(r *runRecord) {
+		r.UUID = uuid
+		r.TaskCreated = time.Now()
+		r.Status = StatusName(STATUS_QUEUED)
+	})
+	taskLog := newTaskLog(outputPath)
+	defer taskLog.Close()
+
+	onStage(StageProcessing)
+
+	var processingTime int
+	var taskError string
+	finish := func(status int, message string) {
+		record.finish(StatusName(status), message)
+		notifyFinished(settings.Notifiers, notify.Notification{
+			Status:         StatusName(status),
+			UUID:           uuid,
+			Node:           node.URL,
+			Output:         outputPath,
+			ProcessingTime: time.Duration(processingTime) * time.Millisecond,
+			Error:          message,
+		}, log)
+	}
 
-		os.Exit(1)
-	}()
+	info, err := node.TaskInfo(uuid)
+	if ctx.Err() != nil {
+		return result, cancelTask(node.WithContext(context.Background()), uuid, outputPath, taskLog, finish, log)
+	} else if err != nil {
+		return result, err
+	}
 
 	// Start listening for output and task updates...
 	status := info.Status.Code
+	wait := &queueWait{since: time.Now()}
+	wait.update(status)
+	processingTime, taskError = info.ProcessingTime, info.Status.ErrorMessage
 	lineNum := 0
+	submodels := newSubmodelProgress()
+
+	// Tokens can expire more than once during a long task, but give up
+	// if the node keeps rejecting the new ones
+	rejectedTokens := 0
+	rejectedTokensLimit := 10
+	reauthenticate := func(err error) error {
+		rejectedTokens++
+		if rejectedTokens >= rejectedTokensLimit {
+			return errors.New("Authentication retries limit exceeded (" + strconv.Itoa(rejectedTokensLimit) + "): " + err.Error())
+		}
+		return refreshToken(&node, settings, log)
+	}
 
 	for status == STATUS_QUEUED || status == STATUS_RUNNING {
-		time.Sleep(3 * time.Second)
+		if !sleep(ctx, 3*time.Second) {
+			return result, cancelTask(node.WithContext(context.Background()), uuid, outputPath, taskLog, finish, log)
+		}
 
 		info, err := node.TaskInfo(uuid)
-		if err != nil {
-			logger.Info(err)
+		if ctx.Err() != nil {
+			return result, cancelTask(node.WithContext(context.Background()), uuid, outputPath, taskLog, finish, log)
+		} else if err == ErrUnauthorized {
+			if err := reauthenticate(err); err != nil {
+				return result, err
+			}
+			continue
+		} else if err != nil {
+			log.Warn(err)
 
 			// Log error, try again later
 			continue
 		}
 
+		if info.Status.Code != status {
+			record.update(func(r *runRecord) {
+				r.Status = StatusName(info.Status.Code)
+				r.ProcessingTime = info.ProcessingTime
+			})
+			log.Event("status", "", logger.Fields{
+				"status": StatusName(info.Status.Code),
+				"code":   info.Status.Code,
+			})
+		}
 		status = info.Status.Code
+		wait.update(status)
+		processingTime, taskError = info.ProcessingTime, info.Status.ErrorMessage
 
 		lines, err := node.TaskOutput(uuid, lineNum)
-		if err != nil {
-			logger.Info(err)
+		if ctx.Err() != nil {
+			return result, cancelTask(node.WithContext(context.Background()), uuid, outputPath, taskLog, finish, log)
+		} else if err == ErrUnauthorized {
+			if err := reauthenticate(err); err != nil {
+				return result, err
+			}
+			continue
+		} else if err != nil {
+			log.Warn(err)
 			continue
 		}
+		rejectedTokens = 0
 
 		for _, line := range lines {
-			logger.Info(line)
+			log.Event("output", line, nil)
+			taskLog.Write(line)
+			if submodels.Parse(line) {
+				log.Event("submodels", submodels.String(), logger.Fields{
+					"completed": submodels.Count(submodelCompleted),
+					"failed":    submodels.Count(submodelFailed),
+					"total":     submodels.Total(),
+				})
+			}
 		}
 		lineNum += len(lines)
 	}
 
-	if status == STATUS_CANCELED || status == STATUS_FAILED {
-		os.Exit(1)
+	result.Status = status
+	result.ProcessingTime = processingTime
+	processingSeconds.Observe(float64(processingTime)/1000, StatusName(status))
+	record.update(func(r *runRecord) {
+		r.ProcessingTime = processingTime
+	})
+
+	if status != STATUS_COMPLETED {
+		taskFailures.Inc(StatusName(status))
+		finish(status, taskError)
+		log.Event(StatusName(status), "", logger.Fields{"error": taskError})
+		if taskError != "" {
+			return result, errors.New("Task " + StatusName(status) + ": " + taskError)
+		}
+		return result, errors.New("Task " + StatusName(status))
 	}
 
-	if status == STATUS_COMPLETED {
-		retryCount := 0
-		retryLimit := 10
+	onStage(StageDownloading)
 
-		archiveDst := path.Join(outputPath, "all.zip")
-		logger.Info("Task completed! Downloading and extracting results...")
-		logger.Info("")
+	archiveDst := path.Join(outputPath, "all.zip")
+	log.Info("Task completed! Downloading and extracting results...")
+	log.Info("")
 
-		for {
-			err := node.TaskDownload(uuid, "all.zip", archiveDst)
-			if err == nil {
-				break
-			} else {
-				logger.Info("Error downloading file (" + err.Error() + ") retrying in " + string(3*retryLimit) + " seconds...")
-				time.Sleep(time.Duration(3*retryLimit) * time.Second)
-				retryCount++
-				if retryCount >= retryLimit {
-					logger.Error("Download retries limit exceeded (" + string(retryLimit) + "), exiting...")
+	if err := downloadAsset(ctx, &node, uuid, "all.zip", archiveDst, settings, log); err != nil {
+		if ctx.Err() != nil {
+			err = ErrCanceled
+		}
+		finish(status, "Cannot download results: "+err.Error())
+		return result, err
+	}
+
+	if settings.CleanOutput {
+		if err := fs.CleanDirectory(outputPath, path.Base(archiveDst), taskLogFile, runRecordFile); err != nil {
+			finish(status, "Cannot clean output directory: "+err.Error())
+			return result, err
+		}
+	}
+
+	// Unzip
+	if _, err := fs.Unzip(archiveDst, outputPath); err != nil {
+		finish(status, "Cannot extract results: "+err.Error())
+		return result, err
+	}
+
+	// Remove
+	if err := os.Remove(archiveDst); err != nil {
+		log.Warn(err)
+	}
+
+	for _, asset := range settings.Assets {
+		if err := downloadExtraAsset(ctx, &node, uuid, asset, outputPath, settings, log); err == ErrCanceled {
+			finish(status, "Cannot download "+asset+": "+err.Error())
+			return result, err
+		} else if err != nil {
+			// The main results are available, keep going
+			log.Warn("Cannot download " + asset + ": " + err.Error())
+		}
+	}
+
+	finish(status, "")
+	log.Event("completed", "Done! Results saved in "+outputPath, logger.Fields{
+		"output":         outputPath,
+		"processingTime": processingTime,
+	})
+
+	return result, nil
+}
+
+// downloadAsset downloads an asset of a task, retrying on errors
+func downloadAsset(ctx context.Context, node *Node, uuid string, asset string, outputFile string, settings RunSettings, log *logger.Logger) error {
+	retryCount := 0
+	retryLimit := 10
+
+	for {
+		started := time.Now()
+		err := node.TaskDownload(uuid, asset, outputFile)
+		if err == nil {
+			if fi, err := os.Stat(outputFile); err == nil {
+				downloadedBytes.Add(float64(fi.Size()))
+				if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+					downloadThroughput.Observe(float64(fi.Size()) / elapsed)
 				}
 			}
+			return nil
+		} else if ctx.Err() != nil {
+			return ErrCanceled
+		} else if err == ErrAssetNotFound {
+			return err
+		} else if err == ErrUnauthorized {
+			// Count these too, the node might keep rejecting new tokens
+			retryCount++
+			if retryCount >= retryLimit {
+				return errors.New("Download retries limit exceeded (" + strconv.Itoa(retryLimit) + "): " + err.Error())
+			}
+			if err := refreshToken(node, settings, log); err != nil {
+				return err
+			}
+		} else {
+			retryCount++
+			if retryCount >= retryLimit {
+				return errors.New("Download retries limit exceeded (" + strconv.Itoa(retryLimit) + "): " + err.Error())
+			}
+			log.Warn("Error downloading file (" + err.Error() + ") retrying in " + strconv.Itoa(3*retryLimit) + " seconds...")
+			if !sleep(ctx, time.Duration(3*retryLimit)*time.Second) {
+				return ErrCanceled
+			}
 		}
+	}
+}
 
-		// Unzip
-		_, err := fs.Unzip(archiveDst, outputPath)
-		if err != nil {
-			logger.Error(err)
-		}
+// downloadExtraAsset downloads an asset other than all.zip to
+// outputPath. Archives are extracted to a directory named after
+// the asset (e.g. orthophoto_tiles.zip to orthophoto_tiles/).
+func downloadExtraAsset(ctx context.Context, node *Node, uuid string, asset string, outputPath string, settings RunSettings, log *logger.Logger) error {
+	name := strings.TrimSuffix(asset, ".zip")
+	archiveDst := path.Join(outputPath, name+".zip")
+
+	log.Info("Downloading " + asset + "...")
+	if err := downloadAsset(ctx, node, uuid, asset, archiveDst, settings, log); err != nil {
+		os.Remove(archiveDst)
+		return err
+	}
 
-		// Remove
-		if err := os.Remove(archiveDst); err != nil {
-			logger.Info(err)
+	if _, err := fs.Unzip(archiveDst, path.Join(outputPath, name)); err == zip.ErrFormat {
+		// Not an archive, keep it as it is
+		return os.Rename(archiveDst, path.Join(outputPath, asset))
+	} else if err != nil {
+		return err
+	}
+
+	return os.Remove(archiveDst)
+}
+
+// queueWait measures the time a task waits in the queue of the node
+type queueWait struct {
+	since    time.Time
+	observed bool
+}
+
+// update records the wait time once the task leaves the queue
+func (q *queueWait) update(status int) {
+	if !q.observed && status != STATUS_QUEUED {
+		queueWaitTime.Observe(time.Since(q.since).Seconds())
+		q.observed = true
+	}
+}
+
+// cancelTask cancels a task on the node after the run has been
+// canceled, removing its log, record and the output directory if
+// nothing else is left in it
+func cancelTask(node Node, uuid string, outputPath string, taskLog *taskLog, finish func(status int, message string), log *logger.Logger) error {
+	log.Info("Canceling task...")
+
+	// Attempt to cancel task first, notifications can take a while
+	retryCount := 0
+	retryLimit := 5
+
+	for retryCount < retryLimit {
+		if err := node.TaskCancel(uuid); err != nil {
+			retryCount++
+			log.Warn(err)
+			time.Sleep(1 * time.Second)
+		} else {
+			break
 		}
+	}
+
+	log.Event("canceled", "", nil)
+	taskFailures.Inc(StatusName(STATUS_CANCELED))
+	finish(STATUS_CANCELED, "")
+
+	// Don't leave the log and record of a canceled run behind,
+	// so that the output directory can be removed if it's empty
+	taskLog.Close()
+	os.Remove(filepath.Join(outputPath, taskLogFile))
+	os.Remove(filepath.Join(outputPath, runRecordFile))
 
-		logger.Info("Done! Results saved in " + outputPath)
+	filesCount, err := fs.DirectoryFilesCount(outputPath)
+	if err == nil && fs.IsDirectory(outputPath) && filesCount == 0 {
+		os.Remove(outputPath)
+	}
+
+	return ErrCanceled
+}
+
+// notifyFinished sends a notification about a finished task
+// to the notifiers that are interested in its status
+func notifyFinished(notifiers map[string]notify.Config, n notify.Notification, log *logger.Logger) {
+	names := []string{}
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !notifiers[name].Wants(n.Status) {
+			continue
+		}
+		if err := notifiers[name].Send(n); err != nil {
+			log.Warn("Cannot send " + name + " notification: " + err.Error())
+		} else {
+			log.Debug("Sent " + name + " notification")
+		}
 	}
 }
@@ -0,0 +1,57 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+)
+
+func TestDownloadRejectedToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"error":"Invalid authentication token"}`))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "odm-download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logins := 0
+	settings := RunSettings{Reauthenticate: func() (string, error) {
+		logins++
+		return "new", nil
+	}}
+
+	node := Node{URL: ts.URL, Token: "expired"}
+	err = downloadAsset(context.Background(), &node, "uuid", "all.zip", filepath.Join(dir, "all.zip"), settings, logger.With(nil))
+	if err == nil {
+		t.Fatal("Download should have failed")
+	}
+	if logins == 0 || logins >= 10 {
+		t.Error("Should have logged in again fewer than 10 times, logged in", logins, "times")
+	}
+}
@@ -22,3 +22,21 @@ const (
 	STATUS_COMPLETED int = 40
 	STATUS_CANCELED  int = 50
 )
+
+// StatusName returns a human readable name for a status code
+func StatusName(code int) string {
+	switch code {
+	case STATUS_QUEUED:
+		return "queued"
+	case STATUS_RUNNING:
+		return "running"
+	case STATUS_FAILED:
+		return "failed"
+	case STATUS_COMPLETED:
+		return "completed"
+	case STATUS_CANCELED:
+		return "canceled"
+	default:
+		return "unknown"
+	}
+}
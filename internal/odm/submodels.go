@@ -0,0 +1,115 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var submodelCountRe = regexp.MustCompile(`(?i)\b(\d+)\s+submodels\b`)
+var submodelNameRe = regexp.MustCompile(`submodel_\d+`)
+
+// submodelProgress tracks the progress of split-merge submodels
+// by parsing the task output
+type submodelProgress struct {
+	total  int
+	status map[string]string
+}
+
+const (
+	submodelRunning   = "running"
+	submodelCompleted = "completed"
+	submodelFailed    = "failed"
+)
+
+func newSubmodelProgress() *submodelProgress {
+	return &submodelProgress{status: map[string]string{}}
+}
+
+// Parse updates the progress from a line of task output and returns
+// true if the progress has changed
+func (p *submodelProgress) Parse(line string) bool {
+	changed := false
+
+	if m := submodelCountRe.FindStringSubmatch(line); m != nil {
+		if total, err := strconv.Atoi(m[1]); err == nil && total > p.total {
+			p.total = total
+			changed = true
+		}
+	}
+
+	name := submodelNameRe.FindString(line)
+	if name == "" {
+		return changed
+	}
+
+	status := submodelRunning
+	lower := strings.ToLower(line)
+	if strings.Contains(lower, "finished successfully") || strings.Contains(lower, "completed") {
+		status = submodelCompleted
+	} else if strings.Contains(lower, "failed") {
+		status = submodelFailed
+	}
+
+	current, ok := p.status[name]
+	if !ok || (current == submodelRunning && status != submodelRunning) {
+		p.status[name] = status
+		changed = true
+	}
+
+	return changed
+}
+
+// Count returns the number of submodels with a status
+func (p *submodelProgress) Count(status string) int {
+	count := 0
+	for _, s := range p.status {
+		if s == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Total returns the total number of submodels, if known
+func (p *submodelProgress) Total() int {
+	if len(p.status) > p.total {
+		return len(p.status)
+	}
+	return p.total
+}
+
+func (p *submodelProgress) String() string {
+	running := []string{}
+	for name, s := range p.status {
+		if s == submodelRunning {
+			running = append(running, name)
+		}
+	}
+	sort.Strings(running)
+
+	str := "Submodels: " + strconv.Itoa(p.Count(submodelCompleted)) + "/" + strconv.Itoa(p.Total()) + " completed"
+	if failed := p.Count(submodelFailed); failed > 0 {
+		str += ", " + strconv.Itoa(failed) + " failed"
+	}
+	if len(running) > 0 {
+		str += " (running: " + strings.Join(running, ", ") + ")"
+	}
+	return str
+}
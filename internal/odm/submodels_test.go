@@ -0,0 +1,49 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"testing"
+)
+
+func TestSubmodelProgress(t *testing.T) {
+	p := newSubmodelProgress()
+
+	if p.Parse("[INFO]    Running opensfm stage") {
+		t.Error("Unrelated lines should not change the progress")
+	}
+	if !p.Parse("[INFO]    Dataset successfully split into 3 submodels") || p.Total() != 3 {
+		t.Error("Total should be 3")
+	}
+
+	p.Parse("[INFO]    LRE: About to process /datasets/code/submodels/submodel_0000 remotely")
+	p.Parse("[INFO]    LRE: About to process /datasets/code/submodels/submodel_0001 remotely")
+	if p.Parse("[INFO]    LRE: Uploading images for submodel_0000") {
+		t.Error("Progress should not change for a running submodel")
+	}
+	p.Parse("[INFO]    LRE: /datasets/code/submodels/submodel_0000 finished successfully")
+	p.Parse("[WARNING] LRE: /datasets/code/submodels/submodel_0001 failed with: timeout")
+
+	if p.Count(submodelCompleted) != 1 || p.Count(submodelFailed) != 1 {
+		t.Error("Expected 1 completed and 1 failed submodel")
+	}
+
+	p.Parse("[INFO]    LRE: About to process /datasets/code/submodels/submodel_0002 remotely")
+	expected := "Submodels: 1/3 completed, 1 failed (running: submodel_0002)"
+	if p.String() != expected {
+		t.Error("Expected \"" + expected + "\", got \"" + p.String() + "\"")
+	}
+}
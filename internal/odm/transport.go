@@ -0,0 +1,215 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenDroneMap/CloudODM/internal/logger"
+)
+
+// Transport holds the HTTP settings used to connect to a node
+type Transport struct {
+	// Proxy URL (by default the HTTP_PROXY/HTTPS_PROXY environment variables are used)
+	Proxy string `json:"proxy,omitempty"`
+
+	// CACert is a PEM bundle of certificate authorities trusted
+	// in addition to the system ones
+	CACert string `json:"caCert,omitempty"`
+
+	// ClientCert and ClientKey are the PEM files of a client certificate (mTLS)
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// ConnectTimeout is the maximum time in seconds to establish a connection
+	ConnectTimeout int `json:"connectTimeout,omitempty"`
+
+	// ReadTimeout is the maximum time in seconds to wait for data
+	// from the node before giving up on a request
+	ReadTimeout int `json:"readTimeout,omitempty"`
+}
+
+var clientsMutex sync.Mutex
+var clients = map[Transport]*http.Client{}
+
+// NewClient creates an HTTP client using the transport settings
+func (t Transport) NewClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+
+	if t.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(t.CACert)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("No certificates found in " + t.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.ClientCert != "" || t.ClientKey != "" {
+		if t.ClientCert == "" || t.ClientKey == "" {
+			return nil, errors.New("Both a client certificate and a client key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if t.Proxy != "" {
+		proxyURL, err := url.Parse(t.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, errors.New(t.Proxy + " is not a valid proxy URL")
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(t.ConnectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	readTimeout := time.Duration(t.ReadTimeout) * time.Second
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil || readTimeout == 0 {
+				return conn, err
+			}
+			return &timeoutConn{conn, readTimeout}, nil
+		},
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// timeoutConn fails reads that do not receive data within timeout,
+// without limiting the total duration of a request (e.g. long downloads)
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// httpClient returns the HTTP client to use with the node
+func (n Node) httpClient() (*http.Client, error) {
+	if n.Transport == nil {
+		return http.DefaultClient, nil
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	if client, ok := clients[*n.Transport]; ok {
+		return client, nil
+	}
+
+	client, err := n.Transport.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	clients[*n.Transport] = client
+
+	return client, nil
+}
+
+// WithContext returns a copy of the node whose requests are
+// canceled when ctx is done
+func (n Node) WithContext(ctx context.Context) Node {
+	n.ctx = ctx
+	return n
+}
+
+// context returns the context of the node's requests
+func (n Node) context() context.Context {
+	if n.ctx == nil {
+		return context.Background()
+	}
+	return n.ctx
+}
+
+// do sends a request, adding the Authorization header
+// if the request is for the node and AuthHeader is set
+func (n Node) do(req *http.Request) (*http.Response, error) {
+	client, err := n.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if n.ctx != nil {
+		req = req.WithContext(n.ctx)
+	}
+
+	if n.AuthHeader && n.Token != "" && strings.HasPrefix(req.URL.String(), n.URL) {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := client.Do(req)
+	if urlErr, ok := err.(*url.Error); ok {
+		// Don't leak tokens in error messages
+		urlErr.URL = logger.Redact(urlErr.URL)
+	}
+	return resp, err
+}
+
+func (n Node) httpGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return n.do(req)
+}
+
+func (n Node) httpPost(url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return n.do(req)
+}
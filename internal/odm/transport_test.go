@@ -0,0 +1,76 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		w.Write([]byte(`{"version":"2.0.0"}`))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "odm-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644)
+
+	node := Node{URL: ts.URL}
+	if _, err := node.Info(); err == nil {
+		t.Error("Self-signed certificate should not be trusted")
+	}
+
+	node.Transport = &Transport{CACert: caCert}
+	if _, err := node.Info(); err != nil {
+		t.Error("Certificate should be trusted with the CA bundle", err)
+	}
+
+	node.Transport = &Transport{InsecureSkipVerify: true}
+	if _, err := node.Info(); err != nil {
+		t.Error("Certificate should not be verified", err)
+	}
+
+	node.Transport = &Transport{CACert: caCert, ReadTimeout: 1}
+	if _, err := node.apiGET("/slow"); err == nil {
+		t.Error("Request should have timed out")
+	}
+
+	node.Transport = &Transport{CACert: filepath.Join(dir, "missing.pem")}
+	if _, err := node.Info(); err == nil {
+		t.Error("Missing CA bundle should return an error")
+	}
+
+	node.Transport = &Transport{ClientCert: caCert}
+	if _, err := node.Info(); err == nil {
+		t.Error("Client certificate without a key should return an error")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaxAutoConnections is the upper limit of parallel
+// upload connections when tuning them automatically
+const MaxAutoConnections = 16
+
+// tuneInterval is how often the number of connections is adjusted
+const tuneInterval = 10 * time.Second
+
+// maxUploadBackoff is the longest wait before retrying
+// an upload that the node rejected with HTTP 429
+const maxUploadBackoff = 30 * time.Second
+
+// uploadBackoff returns how long to wait before retrying an upload
+// rejected with HTTP 429, doubling with every retry
+func uploadBackoff(retries int) time.Duration {
+	if retries >= 5 {
+		return maxUploadBackoff
+	}
+	return time.Duration(1<<uint(retries)) * time.Second
+}
+
+// byteCounter counts the bytes written to it, from multiple
+// uploads at the same time, to measure the upload throughput
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+// reset returns the bytes counted since the last reset
+func (c *byteCounter) reset() int64 {
+	return atomic.SwapInt64(&c.n, 0)
+}
+
+// connectionTuner adjusts the number of parallel upload connections
+// to maximize throughput. It starts small and keeps moving in the
+// same direction (more or fewer connections) while throughput improves,
+// turns around when it gets worse and halves the connections when
+// uploads fail or the node asks to slow down (HTTP 429), holding them
+// for an interval to measure the new throughput.
+type connectionTuner struct {
+	min         int
+	max         int
+	connections int
+	step        int
+
+	// throughput of the previous interval (0 if unknown)
+	last float64
+
+	// hold keeps the connections for the next interval
+	hold bool
+}
+
+// improvement is the relative change in throughput considered significant
+const improvement = 0.1
+
+func newConnectionTuner(min int, max int) *connectionTuner {
+	t := &connectionTuner{min: min, max: max, connections: 2, step: 1}
+	if t.connections > max {
+		t.connections = max
+	}
+	if t.connections < min {
+		t.connections = min
+	}
+	return t
+}
+
+// next returns the number of connections to use in the next interval,
+// given the throughput (bytes/s) of the last interval and whether
+// any upload failed or was throttled
+func (t *connectionTuner) next(throughput float64, failed bool) int {
+	switch {
+	case failed:
+		t.connections /= 2
+		if t.connections < t.min {
+			t.connections = t.min
+		}
+		t.step = 1
+
+		// Measure again before moving
+		t.hold = true
+		t.last = 0
+		return t.connections
+	case t.hold:
+		t.hold = false
+	case t.last == 0:
+		t.move()
+	case throughput >= t.last*(1+improvement):
+		t.move()
+	case throughput <= t.last*(1-improvement):
+		t.step = -t.step
+		t.move()
+	}
+
+	t.last = throughput
+	return t.connections
+}
+
+// move changes the connections by one step, within the limits
+func (t *connectionTuner) move() {
+	if n := t.connections + t.step; n >= t.min && n <= t.max {
+		t.connections = n
+	}
+}
+
+// connectionLimiter limits how many uploads run at the same time
+type connectionLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+	closed bool
+}
+
+func newConnectionLimiter(limit int) *connectionLimiter {
+	l := &connectionLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits for a free connection. It returns false
+// if the limiter was closed in the meantime.
+func (l *connectionLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= l.limit && !l.closed {
+		l.cond.Wait()
+	}
+	if l.closed {
+		return false
+	}
+	l.active++
+	return true
+}
+
+func (l *connectionLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Broadcast()
+}
+
+func (l *connectionLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// close wakes up and stops all waiting uploads
+func (l *connectionLimiter) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.cond.Broadcast()
+}
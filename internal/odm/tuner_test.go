@@ -0,0 +1,99 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package odm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConnectionTuner(t *testing.T) {
+	tuner := newConnectionTuner(1, 5)
+	if tuner.connections != 2 {
+		t.Fatal("Expected to start with 2 connections")
+	}
+
+	expect := func(throughput float64, failed bool, connections int) {
+		t.Helper()
+		if n := tuner.next(throughput, failed); n != connections {
+			t.Error("Expected " + strconv.Itoa(connections) + " connections, got " + strconv.Itoa(n))
+		}
+	}
+
+	// Grows while throughput improves, up to the maximum
+	expect(100, false, 3)
+	expect(150, false, 4)
+	expect(200, false, 5)
+	expect(250, false, 5)
+
+	// Turns around when throughput drops
+	expect(240, false, 5)
+	expect(180, false, 4)
+
+	// Holds when throughput doesn't change much
+	expect(185, false, 4)
+	expect(180, false, 4)
+
+	// Backs off on errors, then measures again before moving
+	expect(100, true, 2)
+	expect(100, true, 1)
+	expect(100, true, 1)
+	expect(100, false, 1)
+	expect(150, false, 2)
+	expect(200, false, 3)
+}
+
+func TestConnectionLimiter(t *testing.T) {
+	l := newConnectionLimiter(1)
+	if !l.acquire() {
+		t.Fatal("Expected a free connection")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- l.acquire()
+	}()
+
+	l.setLimit(2)
+	if !<-acquired {
+		t.Error("Expected a connection after raising the limit")
+	}
+
+	go func() {
+		acquired <- l.acquire()
+	}()
+	l.close()
+	if <-acquired {
+		t.Error("Expected no connection after closing")
+	}
+}
+
+func TestUploadThroughput(t *testing.T) {
+	c := &byteCounter{}
+	c.Write(make([]byte, 100))
+	c.Write(make([]byte, 50))
+	if n := c.reset(); n != 150 {
+		t.Error("Expected 150 bytes, got", n)
+	}
+	if n := c.reset(); n != 0 {
+		t.Error("Expected the counter to be reset, got", n)
+	}
+
+	if uploadBackoff(0) != time.Second || uploadBackoff(3) != 8*time.Second || uploadBackoff(100) != maxUploadBackoff {
+		t.Error("Unexpected upload backoff")
+	}
+}
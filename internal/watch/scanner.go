@@ -0,0 +1,148 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReadyMarker is a file that marks a folder as ready to be processed
+// without waiting for it to be stable
+const ReadyMarker = ".ready"
+
+// Scanner finds the subfolders of a directory that are ready to be
+// processed: those containing a .ready file, or whose contents have
+// not changed for StableTime.
+type Scanner struct {
+	Dir        string
+	StableTime time.Duration
+
+	// Skip lists subfolder names to ignore
+	Skip []string
+
+	folders map[string]*folder
+}
+
+type folder struct {
+	snapshot snapshot
+	since    time.Time
+	ready    bool
+}
+
+// snapshot summarizes the contents of a folder to detect changes
+type snapshot struct {
+	files   int
+	size    int64
+	modTime time.Time
+	marker  bool
+}
+
+// NewScanner creates a scanner for the subfolders of dir
+func NewScanner(dir string, stableTime time.Duration, skip ...string) *Scanner {
+	return &Scanner{Dir: dir, StableTime: stableTime, Skip: skip, folders: map[string]*folder{}}
+}
+
+// Scan returns the names of the subfolders that became ready since the
+// previous scan, sorted alphabetically. A folder is returned only once,
+// unless it's removed and added again.
+func (s *Scanner) Scan(now time.Time) ([]string, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := []string{}
+	seen := map[string]bool{}
+
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || strings.HasPrefix(name, ".") || s.skip(name) {
+			continue
+		}
+		seen[name] = true
+
+		f, ok := s.folders[name]
+		if ok && f.ready {
+			continue
+		}
+
+		snap, err := takeSnapshot(filepath.Join(s.Dir, name))
+		if err != nil {
+			// The folder might be changing, try again later
+			continue
+		}
+
+		if !ok {
+			f = &folder{snapshot: snap, since: now}
+			s.folders[name] = f
+		} else if snap != f.snapshot {
+			f.snapshot = snap
+			f.since = now
+		}
+
+		if snap.marker || (snap.files > 0 && now.Sub(f.since) >= s.StableTime) {
+			f.ready = true
+			ready = append(ready, name)
+		}
+	}
+
+	// Forget folders that have been removed
+	for name := range s.folders {
+		if !seen[name] {
+			delete(s.folders, name)
+		}
+	}
+
+	sort.Strings(ready)
+	return ready, nil
+}
+
+func (s *Scanner) skip(name string) bool {
+	for _, n := range s.Skip {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func takeSnapshot(dir string) (snapshot, error) {
+	snap := snapshot{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if filepath.Base(path) == ReadyMarker {
+			snap.marker = true
+			return nil
+		}
+		snap.files++
+		snap.size += info.Size()
+		if info.ModTime().After(snap.modTime) {
+			snap.modTime = info.ModTime()
+		}
+		return nil
+	})
+	return snap, err
+}
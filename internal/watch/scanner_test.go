@@ -0,0 +1,78 @@
+// Copyright © 2018 CloudODM Contributors
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package watch
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScanner(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "odm-watch")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "flight1", "DCIM"), 0755)
+	os.MkdirAll(filepath.Join(dir, "flight2"), 0755)
+	os.MkdirAll(filepath.Join(dir, "done", "old"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "flight1", "DCIM", "1.jpg"), []byte("1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "done", "old", "1.jpg"), []byte("1"), 0644)
+
+	s := NewScanner(dir, time.Minute, "done")
+	now := time.Now()
+
+	check := func(at time.Duration, expected ...string) {
+		t.Helper()
+		ready, err := s.Scan(now.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ready) != len(expected) {
+			t.Fatalf("Expected %v to be ready, got %v", expected, ready)
+		}
+		for i := range ready {
+			if ready[i] != expected[i] {
+				t.Fatalf("Expected %v to be ready, got %v", expected, ready)
+			}
+		}
+	}
+
+	check(0)
+	check(30 * time.Second)
+
+	// Still changing
+	ioutil.WriteFile(filepath.Join(dir, "flight1", "DCIM", "2.jpg"), []byte("22"), 0644)
+	check(61 * time.Second)
+
+	// Stable for a minute; empty folders are never ready
+	check(121*time.Second, "flight1")
+	check(200 * time.Second)
+
+	// The marker makes a folder ready right away
+	ioutil.WriteFile(filepath.Join(dir, "flight2", "1.jpg"), []byte("1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "flight2", ReadyMarker), []byte{}, 0644)
+	check(201*time.Second, "flight2")
+
+	// Removed and added again
+	os.RemoveAll(filepath.Join(dir, "flight1"))
+	check(202 * time.Second)
+	os.MkdirAll(filepath.Join(dir, "flight1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "flight1", ReadyMarker), []byte{}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "flight1", "1.jpg"), []byte("1"), 0644)
+	check(203*time.Second, "flight1")
+}